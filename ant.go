@@ -2,12 +2,14 @@ package ant
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"github.com/google/gousb"
 	"io"
-	"log"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -17,19 +19,20 @@ const (
 	DefaultNetworkNumber = 0x00
 
 	// Configuration Messages
-	MessageChannelUnassign      = 0x41
-	MessageChannelAssign        = 0x42
-	MessageChannelID            = 0x51
-	MessageChannelPeriod        = 0x43
-	MessageChannelSearchTimeout = 0x44
-	MessageChannelFrequency     = 0x45
-	MessageChannelTXPower       = 0x60
-	MessageNetworkKey           = 0x46
-	MessageTXPower              = 0x47
-	MessageProximitySearch      = 0x71
-	MessageEnableRXExt          = 0x66
-	MessageLibConfig            = 0x6E
-	MessageChannelOpenRXScan    = 0x5B
+	MessageChannelUnassign          = 0x41
+	MessageChannelAssign            = 0x42
+	MessageChannelID                = 0x51
+	MessageChannelPeriod            = 0x43
+	MessageChannelSearchTimeout     = 0x44
+	MessageLowPrioritySearchTimeout = 0x63
+	MessageChannelFrequency         = 0x45
+	MessageChannelTXPower           = 0x60
+	MessageNetworkKey               = 0x46
+	MessageTXPower                  = 0x47
+	MessageProximitySearch          = 0x71
+	MessageEnableRXExt              = 0x66
+	MessageLibConfig                = 0x6E
+	MessageChannelOpenRXScan        = 0x5B
 
 	// Notification Messages
 	MessageStartup = 0x6F
@@ -154,7 +157,7 @@ func intToLEHexArray(num uint32, numBytes int) []byte {
 	}
 	err := binary.Write(&buf, binary.LittleEndian, num)
 	if err != nil {
-		log.Fatalf("intToLEHexArray unable to writer to buffer")
+		panic(err)
 	}
 	bs := buf.Bytes()
 	return bs[:numBytes]
@@ -163,26 +166,27 @@ func intToLEHexArray(num uint32, numBytes int) []byte {
 func getChecksum(message []byte) int {
 	var checksum int
 	for _, b := range message {
-		checksum = (checksum ^ int(b)) % 0xFF
+		checksum ^= int(b)
 	}
 	return checksum
 }
 
-func setNetworkKey() []byte {
+func setNetworkKey(network uint8, key NetworkKey) []byte {
 	payload := []byte{}
-	payload = append(payload, DefaultNetworkNumber)
-	payload = append(payload, 0xB9)
-	payload = append(payload, 0xA5)
-	payload = append(payload, 0x21)
-	payload = append(payload, 0xFB)
-	payload = append(payload, 0xBD)
-	payload = append(payload, 0x72)
-	payload = append(payload, 0xC3)
-	payload = append(payload, 0x45)
+	payload = append(payload, network)
+	payload = append(payload, key[:]...)
 	return buildMessage(payload, MessageNetworkKey)
 }
 
+// setDevice sets the channel id, device numbers above 16 bits go in the
+// upper nibble of the transmission type.
+// setDevice sets the channel id to pair with. The upper nibble of a 20 bit
+// deviceID goes into the transmission type, unless that is a wildcard: then
+// the stick pairs with any device sharing the lower 16 bits.
 func setDevice(channel, deviceID, deviceType, transmissionType uint32) []byte {
+	if transmissionType != 0 {
+		transmissionType |= (deviceID >> 16 & 0x0F) << 4
+	}
 	payload := []byte{}
 	bs := intToLEHexArray(channel, 1)
 	payload = append(payload, bs...)
@@ -202,13 +206,27 @@ func searchChannel(channel, timeout uint32) []byte {
 	return buildMessage(payload, MessageChannelSearchTimeout)
 }
 
+func lowPrioritySearchChannel(channel, timeout uint32) []byte {
+	payload := []byte{}
+	payload = append(payload, intToLEHexArray(channel, 1)...)
+	payload = append(payload, intToLEHexArray(timeout, 1)...)
+	return buildMessage(payload, MessageLowPrioritySearchTimeout)
+}
+
 func setPeriod(channel, period uint32) []byte {
 	payload := []byte{}
 	payload = append(payload, intToLEHexArray(channel, 1)...)
-	payload = append(payload, intToLEHexArray(period, 1)...)
+	payload = append(payload, intToLEHexArray(period, 2)...)
 	return buildMessage(payload, MessageChannelPeriod)
 }
 
+func setChannelTXPower(channel, power uint32) []byte {
+	payload := []byte{}
+	payload = append(payload, intToLEHexArray(channel, 1)...)
+	payload = append(payload, intToLEHexArray(power, 1)...)
+	return buildMessage(payload, MessageChannelTXPower)
+}
+
 func setFrequency(channel, frequency uint32) []byte {
 	payload := []byte{}
 	payload = append(payload, intToLEHexArray(channel, 1)...)
@@ -237,29 +255,13 @@ func openRXScan() []byte {
 	return buildMessage(payload, MessageChannelOpenRXScan)
 }
 
-func assignChannel(channel uint32, channelType string) []byte {
+func assignChannel(channel uint32, channelType ChannelType, network uint8) []byte {
 	payload := []byte{}
 	bs := intToLEHexArray(channel, 1)
 	payload = append(payload, bs...)
-	switch channelType {
-	case "receive":
-		payload = append(payload, ChannelTypeTwoWayReceive)
-	case "receive_only":
-		payload = append(payload, ChannelTypeOneWayReceive)
-	case "receive_shared":
-		payload = append(payload, ChannelTypeSharedReceive)
-	case "transmit":
-		payload = append(payload, ChannelTypeTwoWayTransmit)
-	case "transmit_only":
-		payload = append(payload, ChannelTypeOneWayTransmit)
-	case "transmit_shared":
-		payload = append(payload, ChannelTypeSharedTransmit)
-	default:
-		panic(fmt.Sprintf("runtime error: invalid channel type %d in assignChannel", channelType))
-	}
-	payload = append(payload, DefaultNetworkNumber)
+	payload = append(payload, byte(channelType), network)
 	return buildMessage(payload, MessageChannelAssign)
-} 
+}
 
 func unassignChannel(channel uint32) []byte {
 	payload := intToLEHexArray(channel, 1)
@@ -288,335 +290,561 @@ func broadcastData(channel uint32, data []byte) []byte {
 	return buildMessage(payload, MessageChannelBroadcastData)
 }
 
-var deviceInUse []*gousb.Device = []*gousb.Device{}
-
-func checkDeviceInUse(desc *gousb.DeviceDesc) bool {
-	for _, device := range deviceInUse {
-		if device.Desc == desc {
-			return true
-		}
-	}
-	return false
+// Transport moves raw bytes to and from an ANT chip. Reads don't have to be
+// aligned to message boundaries, the driver reassembles the frames itself.
+type Transport interface {
+	Read([]byte) (int, error)
+	Write([]byte) (int, error)
+	Close() error
 }
 
 type Driver interface {
-	attach(*BaseSensor, bool) bool
+	// attach takes a free channel for sensor, the scan channel if forScan
+	attach(sensor *BaseSensor, forScan bool) (uint8, error)
 	appendScanner(*BaseSensor)
+	// leaveScan detaches a scanner sharing the scan channel with others.
+	// It's false for the last one, which has to close the channel.
+	leaveScan(*BaseSensor) bool
 	detach(*BaseSensor) bool
 	canScan() bool
-	Open(*gousb.Context) error
+	// stopped tells if the driver was closed or gave up, nothing attaches
+	// until it is opened again
+	stopped() bool
 	Close()
 	isScanning() bool
-	write([]byte) error
+	// writeFor sends data to the stick sensor is attached to
+	writeFor(*BaseSensor, []byte) error
+	// extendedDataFor turns on extended data on the stick sensor is
+	// attached to
+	extendedDataFor(*BaseSensor) error
+	logger() Logger
 }
 
-type USBDriver struct {
-	device               *gousb.Device
-	intf                 *gousb.Interface
-	intfDone             func()
-	detachedKernelDriver bool
-	inEp                 *gousb.InEndpoint
-	inEpReader			 *gousb.ReadStream
-	outEp                *gousb.OutEndpoint
-	leftOver             []byte 
-	usedChannels         int
-	attachedSensors      []*BaseSensor
-	vendorID             gousb.ID
-	productID            gousb.ID
-	startupCallbacks     []func()
-	DoneReading			 chan bool
+// big enough to hold a few frames, whatever the transport hands us
+const readBufferSize = 256
+
+// DefaultStartupTimeout bounds how long Open waits for the stick to come up.
+const DefaultStartupTimeout = 5 * time.Second
 
-	MaxChannels int
-	CanScan     bool
+var (
+	ErrDriverClosed = errors.New("driver closed")
+	ErrDriverOpen   = errors.New("driver already open")
+)
+
+// BaseDriver runs the ANT protocol (framing, startup and sensor dispatch)
+// on top of any Transport. It is safe for concurrent use.
+type BaseDriver struct {
+	// bytes dropped by the decoder, updated atomically
+	discarded uint64
+
+	// everything below is guarded by mu
+	mu           sync.Mutex
+	transport    Transport
+	usedChannels int
+	// who gets the frames of each channel, see dispatch
+	channels         map[uint8]*BaseSensor
+	scanners         []*BaseSensor
+	startupCallbacks []func()
+	recorder         *Recorder
+	log              Logger
+	trace            TraceFunc
+	networkKeys      map[uint8]NetworkKey
+
+	// lifecycle
+	running bool
+	done    chan struct{}
+	err     error
+	// state of the current connection to the stick
+	ready   chan struct{}
+	isReady bool
+	broken  chan struct{}
+	lostErr error
+	info    StickInfo
+	// the stick appends extended data to data messages
+	extendedData bool
+	// requests waiting for an answer
+	requests []*pendingRequest
+
+	disconnectCallbacks []func(error)
+	reconnectCallbacks  []func()
+
+	// set up before the driver starts and only read afterwards
+	// verify, when set, can turn a stick down once it has started up. It
+	// runs on the reader goroutine before the stick is ready.
+	verify            func() error
+	reopen            func() (Transport, error)
+	reconnectInterval time.Duration
+
+	// RequestTimeout bounds the Request methods, DefaultRequestTimeout is
+	// used when it's zero.
+	RequestTimeout time.Duration
+}
+
+func NewBaseDriver() *BaseDriver {
+	return &BaseDriver{}
+}
+
+func (drv *BaseDriver) canScan() bool {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+	return drv.info.CanScan()
+}
+
+func (drv *BaseDriver) stopped() bool {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+	return !drv.running
+}
+
+func (drv *BaseDriver) OnStartup(fn func()) {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+	drv.startupCallbacks = append(drv.startupCallbacks, fn)
 }
 
-func NewUSBDriver(vendorID, productID gousb.ID) *USBDriver {
-	return &USBDriver{
-		vendorID:  vendorID,
-		productID: productID,
-		DoneReading: make(chan bool),
-	}
+// SetRecorder tees every frame read from and written to the stick to r.
+func (drv *BaseDriver) SetRecorder(r *Recorder) {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+	drv.recorder = r
 }
 
-func (drv *USBDriver) canScan() bool {
-	return drv.CanScan
+// SetLogger sends what the driver and its sensors log to l, nil silences
+// them again.
+func (drv *BaseDriver) SetLogger(l Logger) {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+	drv.log = l
 }
 
-func (drv *USBDriver) OnStartup(fn func()) {
-	drv.startupCallbacks = append(drv.startupCallbacks, fn)
+// SetTrace has fn called with every frame read from or written to the
+// stick, nil stops tracing.
+func (drv *BaseDriver) SetTrace(fn TraceFunc) {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+	drv.trace = fn
 }
 
-func (drv *USBDriver) Open(ctx *gousb.Context) error {
-	devs, err := ctx.OpenDevices(func(desc *gousb.DeviceDesc) bool {
-		return desc.Vendor == drv.vendorID &&
-			desc.Product == drv.productID &&
-			!checkDeviceInUse(desc)
-	})
-	if err != nil {
-		return err
+func (drv *BaseDriver) logger() Logger {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+	if drv.log == nil {
+		return nopLogger{}
 	}
-	drv.device = devs[0]
-	// close any other device that may have been open
-	for idx, dev := range devs {
-		// dont close the device we are going to use
-		// we'll need to close it later when we clean up
-		if idx != 0 {
-			dev.Close()
-		}
+	return drv.log
+}
+
+// traced hands a frame to the recorder and the trace hook.
+func (drv *BaseDriver) traced(direction FrameDirection, data []byte) {
+	drv.mu.Lock()
+	recorder, trace := drv.recorder, drv.trace
+	drv.mu.Unlock()
+	if recorder != nil {
+		recorder.record(direction.String(), data)
 	}
-	drv.device.SetAutoDetach(true)
-	drv.intf, drv.intfDone, err = drv.device.DefaultInterface()
-	if err != nil {
-		drv.device.Close()
-		drv.device = nil
-		return err
+	if trace != nil {
+		trace(newFrame(direction, data))
 	}
-	deviceInUse = append(deviceInUse, devs[0])
-	drv.inEp, err = drv.intf.InEndpoint(1)
-	if err != nil {
-		log.Println("couldnt get inep 0")
+}
+
+// Start is StartContext bounded by DefaultStartupTimeout.
+func (drv *BaseDriver) Start(t Transport) error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultStartupTimeout)
+	defer cancel()
+	return drv.StartContext(ctx, t)
+}
+
+// StartContext resets the stick behind t and blocks until it has reported
+// its capabilities and accepted the network key, then runs the startup
+// callbacks. The driver owns t from then on, t is closed if the stick
+// doesn't come up before ctx is done.
+func (drv *BaseDriver) StartContext(ctx context.Context, t Transport) error {
+	drv.mu.Lock()
+	if drv.running {
+		drv.mu.Unlock()
+		t.Close()
+		return ErrDriverOpen
+	}
+	drv.running = true
+	drv.done = make(chan struct{})
+	drv.err = nil
+	callbacks := drv.startupCallbacks
+	drv.mu.Unlock()
+
+	if err := drv.connect(ctx, t); err != nil {
+		drv.stop(err)
 		return err
 	}
-
-	maxPacketSize := drv.inEp.Desc.MaxPacketSize
-	drv.inEpReader, err = drv.inEp.NewStream(maxPacketSize,3)
-	if err != nil {
-		log.Fatalf("couldn't get stream reader for in endpoint")
+	for _, cb := range callbacks {
+		cb()
 	}
+	return nil
+}
 
-	drv.outEp, err = drv.intf.OutEndpoint(1)
-	if err != nil {
-		log.Println("couldnt get outep 1")
+// connect starts reading from t and waits for the startup sequence to
+// complete. t is closed if that fails.
+func (drv *BaseDriver) connect(ctx context.Context, t Transport) error {
+	drv.mu.Lock()
+	if !drv.running {
+		drv.mu.Unlock()
+		t.Close()
+		return ErrDriverClosed
+	}
+	drv.transport = t
+	drv.ready = make(chan struct{})
+	drv.isReady = false
+	drv.broken = make(chan struct{})
+	drv.lostErr = nil
+	drv.info = StickInfo{}
+	drv.extendedData = false
+	ready, broken, done := drv.ready, drv.broken, drv.done
+	drv.mu.Unlock()
+
+	go drv.readLoop(t)
+	if err := drv.reset(); err != nil {
+		drv.disconnected(t, err)
 		return err
 	}
+	select {
+	case <-ready:
+		return nil
+	case <-broken:
+		drv.mu.Lock()
+		defer drv.mu.Unlock()
+		return drv.lostErr
+	case <-done:
+		return ErrDriverClosed
+	case <-ctx.Done():
+		drv.disconnected(t, ctx.Err())
+		return ctx.Err()
+	}
+}
 
-	drv.reset()
-	
-	go func(){	
-		defer func(){
-			// if we panic in this routine make sure
-			// everything gets closed properly
-			if a := recover(); a != nil {
-				drv.Close()
-			}
-		}()
-		data := make([]byte, drv.inEp.Desc.MaxPacketSize)
-		for {
-			numBytes, err := drv.inEpReader.Read(data)
-			if err != nil {
-				if err == io.EOF {
-					log.Println("inEpReader closed")
-				}
-				break
-			}
+// Done is closed once the driver has stopped, Err then tells why.
+func (drv *BaseDriver) Done() <-chan struct{} {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+	return drv.done
+}
 
+// Err returns nil while the driver is running, ErrDriverClosed after Close,
+// or the error that made it stop.
+func (drv *BaseDriver) Err() error {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+	return drv.err
+}
 
-			if numBytes == 0 {
-				continue
-			}
+// StickInfo returns what the stick reported about itself during startup.
+func (drv *BaseDriver) StickInfo() StickInfo {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+	return drv.info
+}
 
+// starting tells if the stick is still going through its startup sequence.
+func (drv *BaseDriver) starting() bool {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+	return drv.ready != nil && !drv.isReady
+}
 
-			if len(drv.leftOver) > 0 {
-				data = append(drv.leftOver, data...)
-				drv.leftOver = []byte{}
-			}
+func (drv *BaseDriver) setReady() {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+	if !drv.isReady {
+		drv.isReady = true
+		close(drv.ready)
+	}
+}
 
-			if data[0] != MessageTXSync {
-				log.Fatalf("sync byte missing from stream")
-			}
+// stop closes the transport and records why, only the first call counts.
+func (drv *BaseDriver) stop(err error) {
+	drv.mu.Lock()
+	if !drv.running {
+		drv.mu.Unlock()
+		return
+	}
+	drv.running = false
+	t := drv.transport
+	drv.transport = nil
+	drv.err = err
+	done := drv.done
+	drv.mu.Unlock()
+	if t != nil {
+		t.Close()
+	}
+	close(done)
+}
 
-			l := numBytes
-			beginBlock := 0
-			for beginBlock < l {
-				if beginBlock + 1 == l {
-					drv.leftOver = data[beginBlock:]
-					break
-				}
-				blockLen := data[beginBlock + 1]
-				endBlock := beginBlock + int(blockLen) + 4
-				if endBlock > l {
-					drv.leftOver = data[beginBlock:]
-					break
-				}
-				readData := data[beginBlock:endBlock]
-				drv.read(readData)
-				beginBlock = endBlock
-			}
+// disconnected drops t after it failed. A stick that was up is looked for
+// again if the driver knows how to reopen it, otherwise the driver stops.
+func (drv *BaseDriver) disconnected(t Transport, err error) {
+	drv.mu.Lock()
+	if drv.transport != t {
+		// already closed or replaced
+		drv.mu.Unlock()
+		return
+	}
+	drv.transport = nil
+	drv.lostErr = err
+	close(drv.broken)
+	wasReady := drv.isReady
+	drv.mu.Unlock()
+	t.Close()
+
+	if !wasReady {
+		// connect reports this one
+		return
+	}
+	if drv.reopen == nil {
+		drv.stop(err)
+		return
+	}
+	go drv.reconnect(err)
+}
+
+// fail drops the current transport after err.
+func (drv *BaseDriver) fail(err error) {
+	drv.mu.Lock()
+	t := drv.transport
+	drv.mu.Unlock()
+	if t != nil {
+		drv.disconnected(t, err)
+	}
+}
+
+func (drv *BaseDriver) readLoop(t Transport) {
+	defer func() {
+		// if we panic in this routine make sure
+		// everything gets closed properly
+		if a := recover(); a != nil {
+			drv.stop(fmt.Errorf("panic while reading: %v", a))
 		}
-		drv.DoneReading <- true
 	}()
+	var decoder frameDecoder
+	data := make([]byte, readBufferSize)
+	for {
+		numBytes, err := t.Read(data)
+		if err != nil {
+			if err == io.EOF {
+				drv.logger().Log(LevelInfo, "transport closed")
+			}
+			drv.disconnected(t, err)
+			return
+		}
 
-	return nil
+		frames, discarded := decoder.feed(data[:numBytes])
+		if discarded > 0 {
+			atomic.AddUint64(&drv.discarded, uint64(discarded))
+			drv.logger().Log(LevelWarn, "discarded bytes out of sync or failing checksum",
+				Field{"bytes", discarded})
+		}
+		for _, frame := range frames {
+			drv.read(frame)
+		}
+	}
 }
 
+// DiscardedBytes is the number of bytes dropped so far because they were
+// out of sync or belonged to a frame with a bad checksum.
+func (drv *BaseDriver) DiscardedBytes() uint64 {
+	return atomic.LoadUint64(&drv.discarded)
+}
 
-func (drv *USBDriver) write(data []byte) error {
-	fmt.Printf("Writing: % X\n", data)
-	_, err := drv.outEp.Write(data)
+func (drv *BaseDriver) write(data []byte) error {
+	drv.mu.Lock()
+	t := drv.transport
+	drv.mu.Unlock()
+	if t == nil {
+		return ErrDriverClosed
+	}
+	drv.traced(FrameTX, data)
+	_, err := t.Write(data)
 	return err
 }
 
-func (drv *USBDriver) read(data []byte) {
-	messageID := data[2]
-	switch {
-	case messageID == MessageStartup:
-		request := requestMessage(0, MessageCapabilities)
-		drv.write(request)
-	case messageID == MessageCapabilities:
-		drv.MaxChannels = int(data[3])
-		drv.CanScan = (data[7] & 0x06) == 0x06
-		drv.write(setNetworkKey())
-	case messageID == MessageChannelEvent && data[4] == MessageNetworkKey:
-		for _, cb := range drv.startupCallbacks {
-			log.Println("--- calling startupCallback ---")
-			cb()
-		}
-	default:
-		for _, sensor := range drv.attachedSensors {
-			sensor.handleEventMessages(data)
-		}
-	}
+func (drv *BaseDriver) writeFor(sensor *BaseSensor, data []byte) error {
+	return drv.write(data)
 }
 
-func (drv *USBDriver) attach(sensor *BaseSensor, forScan bool) bool {
-	fmt.Println("-----Attempting to attach sensor-------")
+// freeChannels is how many more sensors the stick can track, a stick used
+// for scanning has none left.
+func (drv *BaseDriver) freeChannels() int {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
 	if drv.usedChannels < 0 {
-		log.Println("-------- didnt attach usedChannels < 0")
-		return false
+		return 0
 	}
-	if forScan {
-		if drv.usedChannels != 0 {
-			log.Println("-------- didnt attach usedChannels != 0 and forScan")
-			return false
-		}
-		drv.usedChannels = -1
-	} else {
-		if drv.MaxChannels <= drv.usedChannels {
-			log.Println("------- didnt attach MaxChannels less than usedChannels")
-			return false
-		}
-		drv.usedChannels++
-	}
-	drv.attachedSensors = append(drv.attachedSensors, sensor)
-	fmt.Println("Attached new sensor: ", sensor)
-	fmt.Printf("Now %d sensors attached\n", len(drv.attachedSensors))
-	return true
+	return drv.info.MaxChannels - drv.usedChannels
 }
 
-func (drv *USBDriver) appendScanner(sensor *BaseSensor) {
-	drv.attachedSensors = append(drv.attachedSensors, sensor)
+// idle tells if no channel of the stick is in use.
+func (drv *BaseDriver) idle() bool {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+	return drv.usedChannels == 0
 }
 
-func (drv *USBDriver) detach(sensor *BaseSensor) bool {
-	idx := -1
-	for i, s := range drv.attachedSensors {
-		if s == sensor {
-			idx = i
+func (drv *BaseDriver) read(data []byte) {
+	drv.traced(FrameRX, data)
+	messageID := data[2]
+	payload := data[BufferIndexMessageType+1 : len(data)-1]
+	// the stick answers the same requests outside of startup, then we
+	// only update what we know about it
+	starting := drv.starting()
+	var next []byte
+	switch {
+	case messageID == MessageStartup:
+		next = requestMessage(0, MessageCapabilities)
+	case messageID == MessageCapabilities:
+		drv.mu.Lock()
+		drv.info.decodeCapabilities(payload)
+		drv.mu.Unlock()
+		next = requestMessage(0, MessageVersion)
+	case messageID == MessageVersion:
+		drv.mu.Lock()
+		drv.info.decodeVersion(payload)
+		hasSerial := drv.info.SerialNumberEnabled
+		drv.mu.Unlock()
+		next = drv.networkKeyAfter(-1)
+		if hasSerial {
+			next = requestMessage(0, MessageSerialNumber)
 		}
+	case messageID == MessageSerialNumber:
+		drv.mu.Lock()
+		drv.info.decodeSerialNumber(payload)
+		drv.mu.Unlock()
+		next = drv.networkKeyAfter(-1)
+	case messageID == MessageChannelEvent && len(data) > 5 && data[4] == MessageNetworkKey:
+		if !starting {
+			break
+		}
+		// keys are set one network after the other, the stick is ready
+		// once it accepted the last one
+		network := data[BufferIndexChannelNumber]
+		if code := data[BufferIndexMessageData+1]; code != ResponseNoError {
+			drv.fail(&ChannelError{
+				Channel:   network,
+				MessageID: MessageNetworkKey,
+				Code:      ResponseCode(code),
+			})
+			return
+		}
+		next = drv.networkKeyAfter(int(network))
+		if next == nil {
+			// a stick turned down never counts as ready, losing it is
+			// not a disconnect
+			if drv.verify != nil {
+				if err := drv.verify(); err != nil {
+					drv.fail(err)
+					return
+				}
+			}
+			drv.setReady()
+		}
+	case messageID == MessageChannelEvent && len(data) > 5 &&
+		(data[4] == MessageEnableRXExt || data[4] == MessageLibConfig):
+		// settings of the whole stick, answered through command rather
+		// than the channel the answer comes on
+	default:
+		drv.dispatch(data)
 	}
-	if idx < 0 {
-		return false
-	}
-	if drv.usedChannels < 0 {
-		drv.usedChannels = 0
-	} else {
-		drv.usedChannels--
+	if starting && next != nil {
+		if err := drv.write(next); err != nil {
+			drv.fail(err)
+		}
 	}
-	drv.attachedSensors[idx], drv.attachedSensors[len(drv.attachedSensors)-1] =
-		drv.attachedSensors[len(drv.attachedSensors)-1], drv.attachedSensors[idx]
-	drv.attachedSensors = drv.attachedSensors[:len(drv.attachedSensors)-1]
-	return true
+	drv.answer(data)
 }
 
-func (drv *USBDriver) detachAll() {
-	for _, sensor := range drv.attachedSensors {
-		sensor.detach()
+func (drv *BaseDriver) detachAll() {
+	for _, sensor := range drv.sensors() {
+		sensor.drop()
 	}
 }
 
-func (drv *USBDriver) Close() {
+// Close detaches every sensor and releases the transport. It is safe to call
+// more than once and from any goroutine.
+func (drv *BaseDriver) Close() {
 	drv.detachAll()
-	drv.inEpReader.Close()
-	drv.inEpReader = nil
-	drv.intfDone()
-	drv.device.Close()
-	drv.device = nil
-	drv.intf = nil
-	drv.intfDone = nil
+	drv.stop(ErrDriverClosed)
 }
 
-func (drv *USBDriver) reset() {
+func (drv *BaseDriver) reset() error {
 	drv.detachAll()
-	drv.MaxChannels = 0
+	drv.mu.Lock()
 	drv.usedChannels = 0
-	drv.write(resetSystem())
+	drv.mu.Unlock()
+	return drv.write(resetSystem())
 }
 
-func (drv *USBDriver) isScanning() bool {
+func (drv *BaseDriver) isScanning() bool {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
 	return drv.usedChannels == -1
 }
 
-func (drv *USBDriver) getDevices(ctx gousb.Context) []*gousb.Device {
-	devs, err := ctx.OpenDevices(func(desc *gousb.DeviceDesc) bool {
-		return desc.Vendor == drv.vendorID && desc.Product == drv.productID
-	})
-	if err != nil {
-		log.Fatalf("OpenDevices(): %v", err)
-	}
-	return devs
-}
-
-type GarminStick2 struct {
-	USBDriver
-}
-
-func NewGarminStick2() *GarminStick2 {
-	return &GarminStick2{
-		USBDriver: USBDriver{
-			vendorID:  0x0FCF,
-			productID: 0x1008,
-		},
-	}
-}
-
-type GarminStick3 struct {
-	USBDriver
-}
-
-func NewGarminStick3() *GarminStick3 {
-	return &GarminStick3{
-		USBDriver: USBDriver{
-			vendorID:  0x0FCF,
-			productID: 0x1009,
-		},
-	}
-}
 
+// BaseSensor is safe for concurrent use. Data and status callbacks run on
+// the driver's reader goroutine.
 type BaseSensor struct {
-	channel            *uint32
-	deviceID           uint32
-	transmissionType   uint32
 	driver             Driver
-	messageQueue	   []Message
 	decodeDataCallback func([]byte)
+
+	// everything below is guarded by mu
+	mu                 sync.Mutex
+	channel            *uint32
+	id                 ChannelID
+	// the id the sensor was attached with, id is what the channel paired
+	// with
+	want               ChannelID
+	// the channel paired with the wrong device and is being closed
+	foreign            bool
+	messageQueue	   []Message
 	statusCallback     func(byte, byte) bool
 	onAttach		   func()
-}
-
+	eventCallbacks     []func(SensorEvent)
+	// the channel is open and hasn't heard from the device yet
+	searching          bool
+	network            uint8
+	// attaches the sensor again the way it was last attached
+	reattach           func() error
+	// how a lost device is reacquired, when it dropped out and the wait
+	// before the next try, see reacquire.go
+	policy             *ReacquirePolicy
+	lostAt             time.Time
+	backoff            time.Duration
+	retry              *time.Timer
+	// receives how the configuration sequence or detach being waited for
+	// ended
+	result             chan error
+}
+
+// SetOnAttachCallback sets f to be called once the sensor's channel is open.
+// It runs on the driver's reader goroutine, like OnEvent callbacks.
 func (sensor *BaseSensor) SetOnAttachCallback(f func()) {
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
 	sensor.onAttach = f
 }
 
 type Sensor interface {
-	updateState(uint32, []byte)
+	updateState(ChannelID, []byte, ExtendedData)
 }
 
-type SendCallback func(bool)
+// SendCallback is called once the stick is done with a message, err is nil
+// if it was delivered.
+type SendCallback func(err error)
 type Message struct {
 	msg		 []byte
 	callback SendCallback
 }
 
+// how long attach, scan and detach wait for the stick to go through the
+// whole sequence of messages
+const attachTimeout = 3 * time.Second
+
 // need to get a callback to the driver somehow
 func NewBaseSensor(driver Driver) *BaseSensor {
 	return &BaseSensor{
@@ -624,215 +852,439 @@ func NewBaseSensor(driver Driver) *BaseSensor {
 	}
 }
 
-func (sensor *BaseSensor) scan(channelType string, frequency uint32) error {
-	log.Println("---scannning---")
-	if sensor.channel != nil {
-		log.Println("sensor already attached")
-		return errors.New("sensor already attached")
+func (sensor *BaseSensor) scan(cfg ChannelConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if sensor.attachedChannel() != nil {
+		return ErrAlreadyAttached
 	}
 
 	if !sensor.driver.canScan() {
-		log.Println("usb stick cannot scan")
-		return errors.New("usb stick cannot scan")
+		return ErrCannotScan
 	}
 
 	var channel uint32 = 0
+	msgs := cfg.scanMessages(channel)
+	reattach := func() error {
+		return sensor.scan(cfg)
+	}
 
 	onStatus := func(msg, code byte) bool {
+		if msg != MessageRF && code != ResponseNoError {
+			return sensor.failed(channel, msg, code)
+		}
 		switch msg {
 		case MessageRF:
-			switch code {
-			case EventChannelClosed, EventRXFailGoToSearch:
-				sensor.write(unassignChannel(channel))
-				return true
-			case EventTransferTXCompleted, EventTransferTXFailed,
-				EventRXFailed, InvalidScanTXChannel:
-				if len(sensor.messageQueue) < 0 {
-					return true
-				}
-				message := sensor.messageQueue[0]
-				if message.callback != nil {
-					message.callback(code == EventTransferTXCompleted)
-				}
-				if len(sensor.messageQueue) == 1 {
-					sensor.messageQueue = []Message{}
-					return true
-				}
-				sensor.messageQueue = sensor.messageQueue[1:]
-				sensor.write(sensor.messageQueue[0].msg)
-				return true
-			}
+			return sensor.channelEvent(channel, code)
 		case MessageChannelAssign:
-			sensor.write(setDevice(channel, 0, 0, 0))
-			return true
-		case MessageChannelID:
-			sensor.write(setFrequency(channel, frequency))
-			return true
-		case MessageChannelFrequency:
-			sensor.write(setRxExt())
-			return true
-		case MessageEnableRXExt:
-			sensor.write(libConfig(channel, 0xE0))
-			return true
-		case MessageLibConfig:
-			sensor.write(openRXScan())
-			return true
+			sensor.emit(SensorAssigned, channel)
 		case MessageChannelOpenRXScan:
-			//TODO emit attached event
-			sensor.onAttach()
+			sensor.settle(nil)
+			sensor.emit(SensorSearching, channel)
+			sensor.attached()
 			return true
 		case MessageChannelClose:
 			return true
 		case MessageChannelUnassign:
-			sensor.statusCallback = nil
-			sensor.channel = nil
-			//TODO emit detached event
+			sensor.driver.detach(sensor)
+			sensor.release()
+			sensor.settle(nil)
+			sensor.emit(SensorUnassigned, channel)
+			return true
+		}
+		if next := nextMessage(msgs, msg); next != nil {
+			sensor.next(next)
 			return true
-		case MessageChannelAcknowledgedData:
-			return code == TransferInProgress
 		}
 		return false
 	}
 
 	if sensor.driver.isScanning() {
-		sensor.channel = &channel
-		sensor.deviceID = 0
-		sensor.transmissionType = 0
-		sensor.statusCallback = onStatus
-		log.Println("attached event")
+		if err := sensor.claim(channel, ChannelID{}, onStatus, reattach); err != nil {
+			return err
+		}
 		sensor.driver.appendScanner(sensor)
-		//TODO "emit" an attach event
-		sensor.onAttach()
-	} else if sensor.driver.attach(sensor, true) {
-		sensor.channel = &channel
-		sensor.deviceID = 0
-		sensor.transmissionType = 0
-		sensor.statusCallback = onStatus
-		sensor.write(assignChannel(channel, channelType))
-
-		log.Println("attached not isscanning")
-	} else {
-		log.Println("cannot attach sensor")
-		return errors.New("cannot attach sensor")
+		sensor.attached()
+		return nil
+	}
+	if err := sensor.claim(channel, ChannelID{}, onStatus, reattach); err != nil {
+		return err
+	}
+	if _, err := sensor.driver.attach(sensor, true); err != nil {
+		sensor.release()
+		return err
+	}
+	if err := sensor.driver.extendedDataFor(sensor); err != nil {
+		sensor.driver.detach(sensor)
+		sensor.release()
+		return err
+	}
+	if err := sensor.configure(msgs[0]); err != nil {
+		sensor.abandon(channel, err)
+		return err
 	}
 	return nil
 }
 
-func (sensor *BaseSensor) attach(channel, deviceID, deviceType, timeout, period,
-			frequency, transmissionType uint32, channelType string) error {
-	if sensor.channel != nil { 
-		return errors.New("sensor already attached")	
+// attach configures and opens a channel for cfg on whichever channel the
+// driver has free.
+func (sensor *BaseSensor) attach(cfg ChannelConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
 	}
-	if !sensor.driver.attach(sensor, false) {
-		return errors.New("driver can not attach sensor")
+	if sensor.attachedChannel() != nil {
+		return ErrAlreadyAttached
+	}
+	number, err := sensor.driver.attach(sensor, false)
+	if err != nil {
+		return err
+	}
+	channel := uint32(number)
+	msgs := cfg.messages(channel)
+	reattach := func() error {
+		return sensor.attach(cfg)
 	}
-	sensor.channel = &channel
-	sensor.deviceID = deviceID
-	sensor.transmissionType = transmissionType
 
 	onStatus := func(msg, code byte) bool {
+		if msg != MessageRF && code != ResponseNoError {
+			return sensor.failed(channel, msg, code)
+		}
 		switch msg {
 		case MessageRF:
-			switch code {
-			case EventChannelClosed, EventRXFailGoToSearch:
-				sensor.write(unassignChannel(channel))
-				return true
-			case EventTransferTXCompleted, EventTransferTXFailed,
-				EventRXFailed, InvalidScanTXChannel:
-				if len(sensor.messageQueue) < 0 {
-					return true
-				}
-				message := sensor.messageQueue[0]
-				if message.callback != nil {
-					message.callback(code == EventTransferTXCompleted)
-				}
-				if len(sensor.messageQueue) == 1 {
-					sensor.messageQueue = []Message{}
-					return true
-				}
-				sensor.messageQueue = sensor.messageQueue[1:]
-				sensor.write(sensor.messageQueue[0].msg)
-				return true
-			}
+			return sensor.channelEvent(channel, code)
 		case MessageChannelAssign:
-			sensor.write(setDevice(channel, deviceID, deviceType, transmissionType))
-			return true
-		case MessageChannelID:
-			sensor.write(searchChannel(channel, timeout))
-			return true
-		case MessageChannelSearchTimeout:
-			sensor.write(setFrequency(channel, frequency))
-			return true
-		case MessageChannelFrequency:
-			sensor.write(setPeriod(channel, period))
-			return true
-		case MessageChannelPeriod:
-			sensor.write(libConfig(channel, 0xE0))
-			return true
-		case MessageLibConfig:
-			sensor.write(openChannel(channel))
-			return true
+			sensor.emit(SensorAssigned, channel)
 		case MessageChannelOpen:
-			//TODO emit attached event
-			sensor.onAttach()
+			sensor.settle(nil)
+			sensor.emit(SensorSearching, channel)
+			sensor.attached()
 			return true
 		case MessageChannelClose:
 			return true
 		case MessageChannelUnassign:
-			sensor.statusCallback = nil
-			sensor.channel = nil
-			//TODO emit detached event
+			// nobody waits for the stick unassigning a lost device's channel
+			lost := !sensor.waiting()
+			sensor.driver.detach(sensor)
+			sensor.release()
+			sensor.settle(nil)
+			sensor.emit(SensorUnassigned, channel)
+			if lost {
+				sensor.reacquire(cfg, channel)
+			}
+			return true
+		}
+		if next := nextMessage(msgs, msg); next != nil {
+			sensor.next(next)
 			return true
-		case MessageChannelAcknowledgedData:
-			return code == TransferInProgress
 		}
 		return false
 	}
 
+	if err := sensor.claim(channel, cfg.channelID(), onStatus, reattach); err != nil {
+		sensor.driver.detach(sensor)
+		return err
+	}
+	if cfg.ExtendedData {
+		if err := sensor.driver.extendedDataFor(sensor); err != nil {
+			sensor.driver.detach(sensor)
+			sensor.release()
+			return err
+		}
+	}
+	if err := sensor.configure(msgs[0]); err != nil {
+		sensor.abandon(channel, err)
+		return err
+	}
+	return nil
+}
+
+// claim takes channel for the sensor, unless it is attached already.
+func (sensor *BaseSensor) claim(channel uint32, id ChannelID,
+	onStatus func(byte, byte) bool, reattach func() error) error {
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
+	if sensor.channel != nil {
+		return ErrAlreadyAttached
+	}
+	sensor.channel = &channel
+	sensor.id = id
+	sensor.want = id
+	sensor.foreign = false
 	sensor.statusCallback = onStatus
-	sensor.write(assignChannel(channel, channelType))
+	sensor.reattach = reattach
+	// a device lost before is being looked for on the new channel
+	sensor.stopRetry()
 	return nil
 }
 
-func (sensor *BaseSensor) detach() {
-	//TODO do.
-	if sensor.channel == nil {
+// release forgets the channel, the sensor can be attached again.
+func (sensor *BaseSensor) release() {
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
+	sensor.channel = nil
+	sensor.statusCallback = nil
+	sensor.messageQueue = nil
+}
+
+// attachedChannel returns the channel the sensor is on, nil if none.
+func (sensor *BaseSensor) attachedChannel() *uint32 {
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
+	return sensor.channel
+}
+
+func (sensor *BaseSensor) attached() {
+	sensor.mu.Lock()
+	onAttach := sensor.onAttach
+	sensor.mu.Unlock()
+	if onAttach != nil {
+		onAttach()
+	}
+}
+
+// configure writes the first message of a configuration sequence and waits
+// for the status callback to settle it. Don't call it from a data or status
+// callback, the answers would never get read.
+func (sensor *BaseSensor) configure(first []byte) error {
+	result := make(chan error, 1)
+	sensor.mu.Lock()
+	sensor.result = result
+	sensor.mu.Unlock()
+	unset := func() {
+		sensor.mu.Lock()
+		if sensor.result == result {
+			sensor.result = nil
+		}
+		sensor.mu.Unlock()
+	}
+	if err := sensor.write(first); err != nil {
+		unset()
+		return err
+	}
+	timer := time.NewTimer(attachTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-result:
+		return err
+	case <-timer.C:
+		unset()
+		return ErrAttachTimeout
+	}
+}
+
+// waiting tells if a configuration sequence or detach is being waited for.
+func (sensor *BaseSensor) waiting() bool {
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
+	return sensor.result != nil
+}
+
+// settle ends the configuration sequence being waited for, if any.
+func (sensor *BaseSensor) settle(err error) {
+	sensor.mu.Lock()
+	result := sensor.result
+	sensor.result = nil
+	sensor.mu.Unlock()
+	if result != nil {
+		result <- err
+	}
+}
+
+// next writes the next message of a configuration sequence.
+func (sensor *BaseSensor) next(data []byte) {
+	if err := sensor.write(data); err != nil {
+		sensor.settle(err)
+	}
+}
+
+// abandon gives up a channel whose configuration failed with err. The
+// channel is unassigned again, unless assigning it is what failed: it may
+// belong to another sensor then.
+func (sensor *BaseSensor) abandon(channel uint32, err error) {
+	sensor.mu.Lock()
+	sensor.statusCallback = nil
+	sensor.mu.Unlock()
+	var chErr *ChannelError
+	if !errors.As(err, &chErr) || chErr.MessageID != MessageChannelAssign {
+		sensor.write(unassignChannel(channel))
+	}
+	sensor.driver.detach(sensor)
+	sensor.release()
+}
+
+// failed handles an error response to a message sent on channel.
+func (sensor *BaseSensor) failed(channel uint32, msg, code byte) bool {
+	err := &ChannelError{
+		Channel:   uint8(channel),
+		MessageID: msg,
+		Code:      ResponseCode(code),
+	}
+	switch msg {
+	case MessageChannelBroadcastData, MessageChannelAcknowledgedData,
+		MessageChannelBurstData:
+		sensor.sent(err)
+	default:
+		sensor.settle(err)
+	}
+	return true
+}
+
+// channelEvent handles the events the stick sends on its own for channel.
+func (sensor *BaseSensor) channelEvent(channel uint32, code byte) bool {
+	switch code {
+	case EventChannelClosed:
+		sensor.emit(SensorClosed, channel)
+		sensor.write(unassignChannel(channel))
+		return true
+	case EventRXFailGoToSearch:
+		// the channel is still open, it is unassigned once closed
+		sensor.emit(SensorDroppedToSearch, channel)
+		if !sensor.keepSearching(channel) {
+			sensor.write(closeChannel(channel))
+		}
+		return true
+	case EventRXSearchTimeout:
+		// the stick closes the channel next
+		sensor.emit(SensorSearchTimeout, channel)
+		return true
+	case EventRXFailed:
+		// a message missed, the channel is still tracking
+		sensor.emit(SensorRXFailed, channel)
+		return true
+	case EventTransferTXCompleted:
+		sensor.sent(nil)
+		return true
+	case EventTransferTXFailed, InvalidScanTXChannel:
+		sensor.sent(&ChannelError{
+			Channel:   uint8(channel),
+			MessageID: MessageRF,
+			Code:      ResponseCode(code),
+		})
+		return true
+	}
+	return false
+}
+
+// sent completes the oldest queued message and writes the next one.
+func (sensor *BaseSensor) sent(err error) {
+	sensor.mu.Lock()
+	if len(sensor.messageQueue) == 0 {
+		sensor.mu.Unlock()
 		return
 	}
-	// write a close message on the channel
-	sensor.write(closeChannel(*sensor.channel))
+	message := sensor.messageQueue[0]
+	sensor.messageQueue = sensor.messageQueue[1:]
+	var next []byte
+	if len(sensor.messageQueue) > 0 {
+		next = sensor.messageQueue[0].msg
+	}
+	sensor.mu.Unlock()
+
+	if message.callback != nil {
+		message.callback(err)
+	}
+	if next != nil {
+		if err := sensor.write(next); err != nil {
+			sensor.sent(err)
+		}
+	}
+}
+
+// detach closes and unassigns the channel, waiting for the stick to confirm.
+// A scanner leaves the scan channel open for the other scanners.
+func (sensor *BaseSensor) detach() error {
+	sensor.cancelReacquire()
+	var err error
+	channel := sensor.attachedChannel()
+	if channel != nil && !sensor.driver.leaveScan(sensor) {
+		err = sensor.configure(closeChannel(*channel))
+	}
 	sensor.driver.detach(sensor)
+	sensor.release()
+	return err
 }
 
+// drop detaches the sensor without waiting for the stick, which is about to
+// be reset or closed anyway.
+func (sensor *BaseSensor) drop() {
+	sensor.cancelReacquire()
+	channel := sensor.attachedChannel()
+	if channel != nil && !sensor.driver.leaveScan(sensor) {
+		sensor.write(closeChannel(*channel))
+	}
+	sensor.driver.detach(sensor)
+	sensor.release()
+	sensor.settle(ErrDriverClosed)
+	if channel != nil {
+		sensor.emit(SensorUnassigned, *channel)
+	}
+}
+
+// handleEventMessages handles a frame the driver dispatched to the sensor's
+// channel.
 func (sensor *BaseSensor) handleEventMessages(data []byte) {
+	if len(data) <= BufferIndexMessageData+1 {
+		return
+	}
 	messageID := data[BufferIndexMessageType]
-	channel := data[BufferIndexChannelNumber]
 
-	if channel == byte(*sensor.channel) {
-		if messageID == MessageChannelEvent {
-			msg := data[BufferIndexMessageData]
-			code := data[BufferIndexMessageData + 1]
-
-			handled := sensor.statusCallback != nil && sensor.statusCallback(msg, code)
-			if !handled {
-				log.Println("Unhandled event: ", data)
-				//TODO emit an eventData event with message and code
-			}
-		} else if sensor.decodeDataCallback != nil {
-			sensor.decodeDataCallback(data)
+	if messageID == MessageChannelEvent {
+		msg := data[BufferIndexMessageData]
+		code := data[BufferIndexMessageData + 1]
+
+		sensor.mu.Lock()
+		statusCallback := sensor.statusCallback
+		sensor.mu.Unlock()
+		handled := statusCallback != nil && statusCallback(msg, code)
+		if !handled {
+			sensor.driver.logger().Log(LevelDebug, "unhandled channel event",
+				channelField(data[BufferIndexChannelNumber]), messageField(msg),
+				Field{"code", ResponseCode(code)})
+			//TODO emit an eventData event with message and code
 		}
+		return
+	}
+	switch messageID {
+	case MessageChannelBroadcastData, MessageChannelAcknowledgedData,
+		MessageChannelBurstData:
+		if sensor.found() {
+			sensor.emit(SensorTracking, uint32(data[BufferIndexChannelNumber]))
+		}
+	}
+	if sensor.decodeDataCallback != nil {
+		sensor.decodeDataCallback(data)
 	}
 }
 
-func (sensor *BaseSensor) send(msg Message) {
+// send queues msg, messages are written one at a time as the stick reports
+// the previous one done. The error is only about writing msg right away,
+// how the transfer went is reported to its callback.
+func (sensor *BaseSensor) send(msg Message) error {
+	sensor.mu.Lock()
+	if sensor.channel == nil {
+		sensor.mu.Unlock()
+		return ErrNotAttached
+	}
 	sensor.messageQueue = append(sensor.messageQueue, msg)
-	if len(sensor.messageQueue) == 1 {
-		sensor.write(msg.msg)
+	first := len(sensor.messageQueue) == 1
+	sensor.mu.Unlock()
+	if !first {
+		return nil
 	}
+	if err := sensor.write(msg.msg); err != nil {
+		sensor.mu.Lock()
+		if len(sensor.messageQueue) > 0 {
+			sensor.messageQueue = sensor.messageQueue[1:]
+		}
+		sensor.mu.Unlock()
+		return err
+	}
+	return nil
 }
 
-func (sensor *BaseSensor) write(data []byte) {
-	sensor.driver.write(data)
+func (sensor *BaseSensor) write(data []byte) error {
+	return sensor.driver.writeFor(sensor, data)
 }
 
 type AntPlusBaseSensor struct {
@@ -840,14 +1292,15 @@ type AntPlusBaseSensor struct {
 	Sensor
 }
 
-func (sensor *AntPlusBaseSensor) scan(scanType string) {
-	sensor.BaseSensor.scan(scanType, 57)
+func (sensor *AntPlusBaseSensor) scan() error {
+	cfg := NewChannelConfig(ChannelReceive).
+		WithNetwork(sensor.networkNumber()).
+		WithFrequency(AntPlusFrequency)
+	return sensor.BaseSensor.scan(cfg)
 }
 
-func (sensor *AntPlusBaseSensor) attach(channel, deviceID, deviceType,
-	transmissionType, timeout, period uint32, channelType string) {
-	sensor.BaseSensor.attach(channel, deviceID, deviceType, transmissionType, 
-		timeout, period, 57, channelType)
+func (sensor *AntPlusBaseSensor) attach(cfg ChannelConfig) error {
+	return sensor.BaseSensor.attach(cfg)
 }
 
 type AntPlusSensor struct {
@@ -871,24 +1324,107 @@ func (sensor *AntPlusSensor) scan() {
 	panic("AntPlusSensor does not support scanning")
 }
 
-func (sensor *AntPlusSensor) attach(channel, deviceID, deviceType, transmissionType,
-	timeout, period uint32, channelType string) {
-	sensor.AntPlusBaseSensor.attach(channel, deviceID, deviceType,
-		transmissionType, timeout, period, channelType)
+func (sensor *AntPlusSensor) attach(cfg ChannelConfig) error {
+	return sensor.AntPlusBaseSensor.attach(cfg)
+}
+
+// AttachConfig opens a channel set up as cfg, for when a profile's Attach
+// defaults don't fit. It waits for the stick to open the channel, the
+// device itself may only be found later. Event, attach and data callbacks
+// run on the driver's reader goroutine, the one reading the stick's answers:
+// called from one of them AttachConfig can only fail with ErrAttachTimeout,
+// start a goroutine instead.
+func (sensor *AntPlusSensor) AttachConfig(cfg ChannelConfig) error {
+	return sensor.attach(cfg)
+}
+
+// Detach closes the sensor's channel, it can be attached again afterwards.
+// It waits for the stick and can't be called from a callback, like
+// AttachConfig.
+func (sensor *AntPlusSensor) Detach() error {
+	return sensor.detach()
+}
+
+// profileConfig is the channel config of an ANT+ profile, searching until
+// the device with deviceID is found. A zero deviceID pairs with the first
+// device of the profile found.
+func (sensor *AntPlusSensor) profileConfig(deviceType uint8, period uint16,
+	deviceID uint32) ChannelConfig {
+	return NewChannelConfig(ChannelReceive).
+		WithNetwork(sensor.networkNumber()).
+		WithDevice(deviceID, deviceType, 0).
+		WithPeriod(period).
+		WithFrequency(AntPlusFrequency).
+		WithSearchTimeout(SearchTimeoutInfinite)
 }
 
 func (sensor *AntPlusSensor) decodeData(data []byte) {
 	switch data[BufferIndexMessageType] {
 	case MessageChannelBroadcastData, MessageChannelAcknowledgedData,
 		MessageChannelBurstData:
-		if sensor.deviceID == 0 {
-			sensor.write(requestMessage(*sensor.channel, MessageChannelID))
+		if len(data) < BufferIndexMessageData+8 {
+			return
+		}
+		ext := decodeExtendedData(data)
+		sensor.mu.Lock()
+		paired := !ext.HasChannelID || sensor.pairedWith(ext.ChannelID)
+		id := sensor.id
+		channel := sensor.channel
+		// a 20 bit device number only matches once the whole id is known
+		unconfirmed := sensor.want.DeviceNumber > 0xFFFF && !id.complete()
+		sensor.mu.Unlock()
+		if !paired {
+			sensor.rejectDevice(ext.ChannelID)
+			return
+		}
+		if !id.complete() && channel != nil {
+			sensor.write(requestMessage(*channel, MessageChannelID))
 		}
-		sensor.updateState(sensor.deviceID, data)
+		if unconfirmed {
+			return
+		}
+		sensor.updateState(id, data, ext)
 	case MessageChannelID:
-		sensor.deviceID = uint32(data[BufferIndexMessageData])
-		sensor.transmissionType = uint32(data[BufferIndexMessageData+3])
+		if len(data) <= BufferIndexMessageData+3 {
+			return
+		}
+		id := decodeChannelID(data[BufferIndexMessageData:])
+		sensor.mu.Lock()
+		paired := sensor.pairedWith(id)
+		sensor.mu.Unlock()
+		if !paired {
+			sensor.rejectDevice(id)
+		}
+	}
+}
+
+// pairedWith records id as the device the channel paired with. It returns
+// false if the sensor was attached to a 20 bit device number id doesn't
+// match, with a wildcard transmission type the stick only compares the lower
+// 16 bits. mu must be held.
+func (sensor *BaseSensor) pairedWith(id ChannelID) bool {
+	if sensor.want.DeviceNumber > 0xFFFF && id.DeviceNumber != sensor.want.DeviceNumber {
+		return false
+	}
+	sensor.id = id
+	return true
+}
+
+// rejectDevice closes the channel, it paired with id instead of the device
+// the sensor was attached to. The sensor is detached like for any lost
+// device, or reacquires it as its policy says.
+func (sensor *BaseSensor) rejectDevice(id ChannelID) {
+	sensor.mu.Lock()
+	channel := sensor.channel
+	closing := sensor.foreign
+	sensor.foreign = true
+	sensor.mu.Unlock()
+	if channel == nil || closing {
+		return
 	}
+	sensor.driver.logger().Log(LevelWarn, "channel paired with another device, closing it",
+		channelField(uint8(*channel)), deviceField(id.DeviceNumber))
+	sensor.write(closeChannel(*channel))
 }
 
 type AntPlusScanner struct {
@@ -899,8 +1435,7 @@ type AntPlusScanner struct {
 type Scanner interface {
 	deviceType() uint32
 	createStateIfNew(uint32)
-	updateRssiAndThreshold(uint32, uint32, uint32)
-	updateState(uint32, []byte)
+	updateState(ChannelID, []byte, ExtendedData)
 }
 
 func NewAntPlusScanner(driver Driver, scanner Scanner) *AntPlusScanner {
@@ -916,8 +1451,11 @@ func NewAntPlusScanner(driver Driver, scanner Scanner) *AntPlusScanner {
 	return &apScanner
 }
 
-func (scanner *AntPlusScanner) Scan() {
-	scanner.AntPlusBaseSensor.scan("receive")
+// Scan puts the stick in scanning mode, or joins the scan already running.
+// It waits for the stick and can't be called from a callback, like
+// AntPlusSensor.AttachConfig.
+func (scanner *AntPlusScanner) Scan() error {
+	return scanner.AntPlusBaseSensor.scan()
 }
 
 func (scanner *AntPlusScanner) attach() {
@@ -929,35 +1467,22 @@ func (scanner *AntPlusScanner) send() {
 }
 
 func (scanner *AntPlusScanner) decodeData(data []byte) {
-	if len(data) <= (BufferIndexExtMessageBegin+3) || 
-		(data[BufferIndexExtMessageBegin] & 0x80)  == 0 {
-			log.Println("wrong message format: ", data)
-			return
-	}	
-
-	idOffset := BufferIndexExtMessageBegin+1
-	deviceID := uint32(binary.LittleEndian.Uint16(data[idOffset:idOffset+2]))
-	deviceType := uint32(data[BufferIndexExtMessageBegin+3])
-
-	if deviceType != scanner.deviceType() {
+	ext := decodeExtendedData(data)
+	if !ext.HasChannelID {
+		scanner.driver.logger().Log(LevelDebug, "scan data without channel id",
+			frameField(data))
 		return
 	}
-	
-
-	scanner.createStateIfNew(deviceID)
-
-	if data[BufferIndexExtMessageBegin] & 0x40 != 0 {
-		if data[BufferIndexExtMessageBegin+5] == 0x20 {
-			scanner.updateRssiAndThreshold(
-				deviceID,
-				uint32(data[BufferIndexExtMessageBegin+6]),
-				uint32(data[BufferIndexExtMessageBegin+7]))
-		}
+	id := ext.ChannelID
+	if uint32(id.DeviceType) != scanner.deviceType() {
+		return
 	}
 
+	scanner.createStateIfNew(id.DeviceNumber)
+
 	switch data[BufferIndexMessageType] {
 		case MessageChannelBroadcastData, MessageChannelAcknowledgedData,
 			MessageChannelBurstData:
-			scanner.updateState(deviceID, data)
+			scanner.updateState(id, data, ext)
 	}
 }
@@ -0,0 +1,222 @@
+package ant
+
+import "fmt"
+
+// ChannelType is how a channel talks to the device on the other end. Its
+// values are the channel type bytes of the assign message.
+type ChannelType byte
+
+const (
+	ChannelReceive        ChannelType = ChannelTypeTwoWayReceive
+	ChannelReceiveOnly    ChannelType = ChannelTypeOneWayReceive
+	ChannelReceiveShared  ChannelType = ChannelTypeSharedReceive
+	ChannelTransmit       ChannelType = ChannelTypeTwoWayTransmit
+	ChannelTransmitOnly   ChannelType = ChannelTypeOneWayTransmit
+	ChannelTransmitShared ChannelType = ChannelTypeSharedTransmit
+)
+
+var channelTypeNames = map[ChannelType]string{
+	ChannelReceive:        "receive",
+	ChannelReceiveOnly:    "receive_only",
+	ChannelReceiveShared:  "receive_shared",
+	ChannelTransmit:       "transmit",
+	ChannelTransmitOnly:   "transmit_only",
+	ChannelTransmitShared: "transmit_shared",
+}
+
+func (t ChannelType) String() string {
+	if name, ok := channelTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("channel type 0x%02X", byte(t))
+}
+
+// AntPlusFrequency is the RF channel every ANT+ device profile uses, 2457MHz.
+const AntPlusFrequency = 57
+
+const (
+	// MaxFrequency is the highest RF channel, 2524MHz
+	MaxFrequency = 124
+	// MaxDeviceID is the highest device number a channel id can hold, 20
+	// bits with the extended nibble of the transmission type
+	MaxDeviceID = 0xFFFFF
+	// SearchTimeoutInfinite keeps a channel searching until it's closed
+	SearchTimeoutInfinite = 0xFF
+)
+
+// ChannelConfig is everything the stick is told about a channel before it's
+// opened. Zero device ID, type and transmission type are wildcards that pair
+// with the first matching device found.
+type ChannelConfig struct {
+	Type    ChannelType
+	Network uint8
+
+	// DeviceID can be 20 bits, the upper nibble is sent in the transmission
+	// type. With a wildcard transmission type the stick only pairs on the
+	// lower 16 bits, the sensor holds back the data until the whole number
+	// is confirmed and closes the channel on a device that doesn't match.
+	DeviceID         uint32
+	DeviceType       uint8
+	TransmissionType uint8
+
+	// Period is the message period in 1/32768s, zero leaves the stick's
+	// default of 8192 (4Hz)
+	Period uint16
+	// Frequency is the RF channel, 2400MHz + Frequency. Zero leaves the
+	// stick's default of 66 (2466MHz), so 2400MHz itself can't be picked.
+	Frequency uint8
+	// SearchTimeout is the high priority search timeout in 2.5s steps, zero
+	// leaves the stick's default of 10 (25s) rather than turning the high
+	// priority search off
+	SearchTimeout uint8
+	// LowPrioritySearchTimeout is in 2.5s steps, nil leaves the stick's
+	// default
+	LowPrioritySearchTimeout *uint8
+	// TXPower is one of the RadioTXPower constants, nil leaves the stick's
+	// default
+	TXPower *uint8
+	// ExtendedData has the stick append the channel id, RSSI and receive
+	// timestamp to every data message. It is a setting of the whole stick,
+	// once on it stays on for every channel until the stick is reset.
+	ExtendedData bool
+}
+
+// NewChannelConfig returns a config for a channel of type t on the default
+// network, pairing with any device.
+func NewChannelConfig(t ChannelType) ChannelConfig {
+	return ChannelConfig{Type: t, Network: DefaultNetworkNumber}
+}
+
+func (cfg ChannelConfig) WithNetwork(network uint8) ChannelConfig {
+	cfg.Network = network
+	return cfg
+}
+
+// WithDevice pairs the channel with a device, zero values are wildcards.
+func (cfg ChannelConfig) WithDevice(deviceID uint32, deviceType, transmissionType uint8) ChannelConfig {
+	cfg.DeviceID = deviceID
+	cfg.DeviceType = deviceType
+	cfg.TransmissionType = transmissionType
+	return cfg
+}
+
+func (cfg ChannelConfig) WithPeriod(period uint16) ChannelConfig {
+	cfg.Period = period
+	return cfg
+}
+
+func (cfg ChannelConfig) WithFrequency(frequency uint8) ChannelConfig {
+	cfg.Frequency = frequency
+	return cfg
+}
+
+func (cfg ChannelConfig) WithSearchTimeout(timeout uint8) ChannelConfig {
+	cfg.SearchTimeout = timeout
+	return cfg
+}
+
+func (cfg ChannelConfig) WithLowPrioritySearchTimeout(timeout uint8) ChannelConfig {
+	cfg.LowPrioritySearchTimeout = &timeout
+	return cfg
+}
+
+func (cfg ChannelConfig) WithTXPower(power uint8) ChannelConfig {
+	cfg.TXPower = &power
+	return cfg
+}
+
+func (cfg ChannelConfig) WithExtendedData() ChannelConfig {
+	cfg.ExtendedData = true
+	return cfg
+}
+
+// Validate reports the first setting the stick would not accept, wrapped in
+// ErrInvalidChannelConfig.
+func (cfg ChannelConfig) Validate() error {
+	if _, ok := channelTypeNames[cfg.Type]; !ok {
+		return fmt.Errorf("%w: unknown %v", ErrInvalidChannelConfig, cfg.Type)
+	}
+	if cfg.DeviceID > MaxDeviceID {
+		return fmt.Errorf("%w: device id %d above %d", ErrInvalidChannelConfig,
+			cfg.DeviceID, MaxDeviceID)
+	}
+	if cfg.DeviceID > 0xFFFF && cfg.TransmissionType&0xF0 != 0 &&
+		uint32(cfg.TransmissionType>>4) != cfg.DeviceID>>16 {
+		return fmt.Errorf("%w: transmission type 0x%02X conflicts with device id %d",
+			ErrInvalidChannelConfig, cfg.TransmissionType, cfg.DeviceID)
+	}
+	if cfg.Frequency > MaxFrequency {
+		return fmt.Errorf("%w: frequency %d above %d", ErrInvalidChannelConfig,
+			cfg.Frequency, MaxFrequency)
+	}
+	if cfg.TXPower != nil && *cfg.TXPower > RadioTXPowerPlus4DB {
+		return fmt.Errorf("%w: tx power %d above %d", ErrInvalidChannelConfig,
+			*cfg.TXPower, RadioTXPowerPlus4DB)
+	}
+	return nil
+}
+
+// channelID is the channel id the config pairs with, zero parts are
+// wildcards.
+func (cfg ChannelConfig) channelID() ChannelID {
+	tt := cfg.TransmissionType
+	if tt != 0 {
+		tt |= byte(cfg.DeviceID>>16&0x0F) << 4
+	}
+	return ChannelID{
+		DeviceNumber:     cfg.DeviceID,
+		DeviceType:       cfg.DeviceType & 0x7F,
+		Pairing:          cfg.DeviceType&0x80 != 0,
+		TransmissionType: tt,
+	}
+}
+
+// messages returns the sequence configuring and opening channel, each one
+// sent once the previous is acknowledged.
+func (cfg ChannelConfig) messages(channel uint32) [][]byte {
+	msgs := [][]byte{
+		assignChannel(channel, cfg.Type, cfg.Network),
+		setDevice(channel, cfg.DeviceID, uint32(cfg.DeviceType), uint32(cfg.TransmissionType)),
+	}
+	if cfg.SearchTimeout != 0 {
+		msgs = append(msgs, searchChannel(channel, uint32(cfg.SearchTimeout)))
+	}
+	if cfg.LowPrioritySearchTimeout != nil {
+		msgs = append(msgs, lowPrioritySearchChannel(channel, uint32(*cfg.LowPrioritySearchTimeout)))
+	}
+	if cfg.Frequency != 0 {
+		msgs = append(msgs, setFrequency(channel, uint32(cfg.Frequency)))
+	}
+	if cfg.Period != 0 {
+		msgs = append(msgs, setPeriod(channel, uint32(cfg.Period)))
+	}
+	if cfg.TXPower != nil {
+		msgs = append(msgs, setChannelTXPower(channel, uint32(*cfg.TXPower)))
+	}
+	return append(msgs, openChannel(channel))
+}
+
+// scanMessages returns the sequence putting the stick in continuous scanning
+// mode on channel. Only the type, network and frequency matter for a scan,
+// extended data is turned on for the whole stick beforehand.
+func (cfg ChannelConfig) scanMessages(channel uint32) [][]byte {
+	msgs := [][]byte{
+		assignChannel(channel, cfg.Type, cfg.Network),
+		setDevice(channel, 0, 0, 0),
+	}
+	if cfg.Frequency != 0 {
+		msgs = append(msgs, setFrequency(channel, uint32(cfg.Frequency)))
+	}
+	return append(msgs, openRXScan())
+}
+
+// nextMessage returns the message following the one acknowledged as msgID,
+// nil if msgID isn't part of msgs or is the last one.
+func nextMessage(msgs [][]byte, msgID byte) []byte {
+	for idx := 0; idx < len(msgs)-1; idx++ {
+		if msgs[idx][BufferIndexMessageType] == msgID {
+			return msgs[idx+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package ant
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestChannelConfigValidate(t *testing.T) {
+	base := NewChannelConfig(ChannelReceive)
+	tests := []struct {
+		name    string
+		cfg     ChannelConfig
+		wantErr bool
+	}{
+		{"wildcards", base, false},
+		{"profile", base.WithDevice(12345, 120, 1).WithPeriod(8070).WithFrequency(AntPlusFrequency), false},
+		{"20 bit device id", base.WithDevice(MaxDeviceID, 120, 0), false},
+		{"matching extended nibble", base.WithDevice(0xA1234, 120, 0xA1), false},
+		{"max frequency", base.WithFrequency(MaxFrequency), false},
+		{"max tx power", base.WithTXPower(RadioTXPowerPlus4DB), false},
+		{"unknown type", ChannelConfig{Type: 0x70}, true},
+		{"device id too big", base.WithDevice(MaxDeviceID+1, 120, 0), true},
+		{"conflicting extended nibble", base.WithDevice(0xA1234, 120, 0xB1), true},
+		{"frequency too high", base.WithFrequency(MaxFrequency + 1), true},
+		{"tx power too high", base.WithTXPower(RadioTXPowerPlus4DB + 1), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidChannelConfig) {
+				t.Errorf("%v doesn't match ErrInvalidChannelConfig", err)
+			}
+		})
+	}
+}
+
+func TestChannelConfigMessages(t *testing.T) {
+	types := func(msgs [][]byte) []byte {
+		var got []byte
+		for _, msg := range msgs {
+			got = append(got, msg[BufferIndexMessageType])
+		}
+		return got
+	}
+	tests := []struct {
+		name string
+		cfg  ChannelConfig
+		want []byte
+	}{
+		{"stick defaults", NewChannelConfig(ChannelReceive),
+			[]byte{MessageChannelAssign, MessageChannelID, MessageChannelOpen}},
+		{"everything set", NewChannelConfig(ChannelReceive).WithPeriod(8070).
+			WithFrequency(AntPlusFrequency).WithSearchTimeout(4).
+			WithLowPrioritySearchTimeout(2).WithTXPower(RadioTXPowerPlus4DB),
+			[]byte{MessageChannelAssign, MessageChannelID, MessageChannelSearchTimeout,
+				MessageLowPrioritySearchTimeout, MessageChannelFrequency,
+				MessageChannelPeriod, MessageChannelTXPower, MessageChannelOpen}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := types(tt.cfg.messages(0)); string(got) != string(tt.want) {
+				t.Errorf("got messages % X, want % X", got, tt.want)
+			}
+		})
+	}
+	if got := types(NewChannelConfig(ChannelReceive).scanMessages(0)); len(got) != 3 {
+		t.Errorf("scan without a frequency sent % X", got)
+	}
+}
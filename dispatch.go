@@ -0,0 +1,153 @@
+package ant
+
+// dispatch hands a frame about a channel to the sensor on that channel. In
+// scan mode the stick reports everything it hears on channel 0, data then
+// goes to every scanner while channel events go to the first one, which
+// owns the channel.
+func (drv *BaseDriver) dispatch(data []byte) {
+	if len(data) <= BufferIndexChannelNumber+1 {
+		return
+	}
+	channel := data[BufferIndexChannelNumber]
+	drv.mu.Lock()
+	sensor := drv.channels[channel]
+	var scanners []*BaseSensor
+	if channel == 0 && drv.usedChannels < 0 {
+		scanners = drv.scanners
+	}
+	drv.mu.Unlock()
+
+	if len(scanners) == 0 {
+		if sensor != nil {
+			sensor.handleEventMessages(data)
+		}
+		return
+	}
+	if data[BufferIndexMessageType] == MessageChannelEvent {
+		scanners[0].handleEventMessages(data)
+		return
+	}
+	for _, scanner := range scanners {
+		scanner.handleEventMessages(data)
+	}
+}
+
+// sensors returns every attached sensor and scanner.
+func (drv *BaseDriver) sensors() []*BaseSensor {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+	sensors := append([]*BaseSensor{}, drv.scanners...)
+	for _, sensor := range drv.channels {
+		sensors = append(sensors, sensor)
+	}
+	return sensors
+}
+
+func (drv *BaseDriver) attach(sensor *BaseSensor, forScan bool) (uint8, error) {
+	channel, err := drv.take(sensor, forScan)
+	if err != nil {
+		drv.logger().Log(LevelDebug, "cannot attach sensor", Field{"scan", forScan},
+			errorField(err))
+		return 0, err
+	}
+	drv.logger().Log(LevelDebug, "sensor attached", channelField(channel),
+		Field{"scan", forScan})
+	return channel, nil
+}
+
+// take puts sensor in the dispatch table on the lowest free channel, the
+// scan channel is always 0.
+func (drv *BaseDriver) take(sensor *BaseSensor, forScan bool) (uint8, error) {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+	if drv.holds(sensor) {
+		return 0, ErrAlreadyAttached
+	}
+	if drv.usedChannels < 0 {
+		// the stick is scanning
+		return 0, ErrCannotAttach
+	}
+	if forScan {
+		if drv.usedChannels != 0 {
+			return 0, ErrCannotAttach
+		}
+		drv.usedChannels = -1
+		drv.scanners = []*BaseSensor{sensor}
+		return 0, nil
+	}
+	for channel := 0; channel < drv.info.MaxChannels; channel++ {
+		if _, ok := drv.channels[uint8(channel)]; ok {
+			continue
+		}
+		if drv.channels == nil {
+			drv.channels = make(map[uint8]*BaseSensor)
+		}
+		drv.usedChannels++
+		drv.channels[uint8(channel)] = sensor
+		return uint8(channel), nil
+	}
+	return 0, &NoFreeChannelError{MaxChannels: drv.info.MaxChannels}
+}
+
+// holds tells if sensor is in the dispatch table, mu must be held.
+func (drv *BaseDriver) holds(sensor *BaseSensor) bool {
+	for _, scanner := range drv.scanners {
+		if scanner == sensor {
+			return true
+		}
+	}
+	for _, s := range drv.channels {
+		if s == sensor {
+			return true
+		}
+	}
+	return false
+}
+
+func (drv *BaseDriver) appendScanner(sensor *BaseSensor) {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+	drv.scanners = append(drv.scanners, sensor)
+}
+
+func (drv *BaseDriver) leaveScan(sensor *BaseSensor) bool {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+	if len(drv.scanners) < 2 {
+		return false
+	}
+	return drv.removeScanner(sensor)
+}
+
+// removeScanner takes sensor out of the scanners, mu must be held. The
+// slice is copied, dispatch may still be going through the old one.
+func (drv *BaseDriver) removeScanner(sensor *BaseSensor) bool {
+	for idx, scanner := range drv.scanners {
+		if scanner == sensor {
+			scanners := make([]*BaseSensor, 0, len(drv.scanners)-1)
+			scanners = append(scanners, drv.scanners[:idx]...)
+			drv.scanners = append(scanners, drv.scanners[idx+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (drv *BaseDriver) detach(sensor *BaseSensor) bool {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+	if drv.removeScanner(sensor) {
+		if len(drv.scanners) == 0 {
+			drv.usedChannels = 0
+		}
+		return true
+	}
+	for channel, s := range drv.channels {
+		if s == sensor {
+			delete(drv.channels, channel)
+			drv.usedChannels--
+			return true
+		}
+	}
+	return false
+}
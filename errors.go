@@ -0,0 +1,98 @@
+package ant
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrAlreadyAttached = errors.New("sensor already attached")
+	ErrNotAttached     = errors.New("sensor not attached")
+	ErrCannotScan      = errors.New("stick cannot scan")
+	ErrCannotAttach    = errors.New("driver can not attach sensor")
+	ErrAttachTimeout   = errors.New("stick did not answer the channel configuration")
+
+	ErrInvalidChannelConfig = errors.New("invalid channel config")
+)
+
+// NoFreeChannelError is returned when attaching a sensor to a stick whose
+// channels are all taken. It matches ErrCannotAttach with errors.Is.
+type NoFreeChannelError struct {
+	MaxChannels int
+}
+
+func (e *NoFreeChannelError) Error() string {
+	return fmt.Sprintf("all %d channels in use", e.MaxChannels)
+}
+
+func (e *NoFreeChannelError) Unwrap() error {
+	return ErrCannotAttach
+}
+
+// ResponseCode is the code of a channel response or event message. Codes
+// other than ResponseNoError are errors themselves, so they can be matched
+// with errors.Is through a ChannelError:
+//
+//	if errors.Is(err, ant.ResponseCode(ant.ChannelInWrongState)) { ... }
+type ResponseCode byte
+
+var responseCodeNames = map[ResponseCode]string{
+	ResponseNoError:             "no error",
+	EventRXSearchTimeout:        "rx search timeout",
+	EventRXFailed:               "rx failed",
+	EventTX:                     "tx",
+	EventTransferRXFailed:       "transfer rx failed",
+	EventTransferTXCompleted:    "transfer tx completed",
+	EventTransferTXFailed:       "transfer tx failed",
+	EventChannelClosed:          "channel closed",
+	EventRXFailGoToSearch:       "rx fail, go to search",
+	EventChannelCollision:       "channel collision",
+	EventTransferTXStart:        "transfer tx start",
+	ChannelInWrongState:         "channel in wrong state",
+	ChannelNotOpened:            "channel not opened",
+	ChannelIDNotSet:             "channel id not set",
+	CloseAllChannels:            "close all channels",
+	TransferInProgress:          "transfer in progress",
+	TransferSequenceNumberError: "transfer sequence number error",
+	TransferInError:             "transfer in error",
+	MessageSizeExceedsLimit:     "message size exceeds limit",
+	InvalidMessage:              "invalid message",
+	InvalidNetworkNumber:        "invalid network number",
+	InvalidListID:               "invalid list id",
+	InvalidScanTXChannel:        "invalid scan tx channel",
+	InvalidParameterProvided:    "invalid parameter provided",
+	EventQueueOverflow:          "event queue overflow",
+	USBStringWriteFail:          "usb string write fail",
+}
+
+func (code ResponseCode) String() string {
+	if name, ok := responseCodeNames[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("code 0x%02X", byte(code))
+}
+
+func (code ResponseCode) Error() string {
+	return code.String()
+}
+
+// ChannelError is an error response or event the stick sent for a channel.
+// MessageID is the message that failed, MessageRF for events that weren't
+// an answer to anything. When setting a network key fails, Channel is the
+// network number.
+type ChannelError struct {
+	Channel   uint8
+	MessageID byte
+	Code      ResponseCode
+}
+
+func (e *ChannelError) Error() string {
+	if e.MessageID == MessageRF {
+		return fmt.Sprintf("channel %d: %v", e.Channel, e.Code)
+	}
+	return fmt.Sprintf("channel %d: message 0x%02X failed: %v", e.Channel, e.MessageID, e.Code)
+}
+
+func (e *ChannelError) Unwrap() error {
+	return e.Code
+}
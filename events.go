@@ -0,0 +1,93 @@
+package ant
+
+import "fmt"
+
+// SensorEventType is what happened to a sensor's channel.
+type SensorEventType int
+
+const (
+	// SensorAssigned: the stick assigned the channel, it is being configured
+	SensorAssigned SensorEventType = iota
+	// SensorSearching: the channel is open and looking for the device
+	SensorSearching
+	// SensorTracking: the device was found, data is coming in
+	SensorTracking
+	// SensorRXFailed: a message from the device was missed
+	SensorRXFailed
+	// SensorSearchTimeout: the device wasn't found in time, the channel
+	// is closing
+	SensorSearchTimeout
+	// SensorDroppedToSearch: too many messages were missed, the channel
+	// went back to searching
+	SensorDroppedToSearch
+	// SensorClosed: the channel is closed
+	SensorClosed
+	// SensorUnassigned: the sensor no longer has a channel
+	SensorUnassigned
+	// SensorLost: the device stayed lost past the ReacquirePolicy's
+	// GiveUpAfter, the sensor won't be attached again
+	SensorLost
+)
+
+var sensorEventNames = map[SensorEventType]string{
+	SensorAssigned:        "assigned",
+	SensorSearching:       "searching",
+	SensorTracking:        "tracking",
+	SensorRXFailed:        "rx failed",
+	SensorSearchTimeout:   "search timeout",
+	SensorDroppedToSearch: "dropped to search",
+	SensorClosed:          "closed",
+	SensorUnassigned:      "unassigned",
+	SensorLost:            "lost",
+}
+
+func (t SensorEventType) String() string {
+	if name, ok := sensorEventNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("SensorEventType(%d)", int(t))
+}
+
+// SensorEvent reports a change on the channel of a sensor or scanner.
+type SensorEvent struct {
+	Type    SensorEventType
+	Channel uint8
+}
+
+// OnEvent registers fn to be called with every event on the sensor's
+// channel. It runs on the driver's reader goroutine, like data listeners,
+// so it must not wait for the stick: to Attach or Detach in response to an
+// event, do it from another goroutine.
+func (sensor *BaseSensor) OnEvent(fn func(SensorEvent)) {
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
+	sensor.eventCallbacks = append(sensor.eventCallbacks, fn)
+}
+
+func (sensor *BaseSensor) emit(t SensorEventType, channel uint32) {
+	sensor.mu.Lock()
+	switch t {
+	case SensorSearching, SensorDroppedToSearch:
+		sensor.searching = true
+	case SensorTracking:
+		sensor.searching = false
+		// the device is back, losing it again starts over
+		sensor.resetReacquire()
+	case SensorClosed, SensorUnassigned:
+		sensor.searching = false
+	}
+	callbacks := sensor.eventCallbacks
+	sensor.mu.Unlock()
+	event := SensorEvent{Type: t, Channel: uint8(channel)}
+	for _, fn := range callbacks {
+		fn(event)
+	}
+}
+
+// found tells if data just came in on a searching channel, which is now
+// tracking.
+func (sensor *BaseSensor) found() bool {
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
+	return sensor.searching && sensor.channel != nil
+}
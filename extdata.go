@@ -0,0 +1,118 @@
+package ant
+
+import "encoding/binary"
+
+// flags telling which parts of the extended data follow a data message
+const (
+	ExtFlagChannelID   = 0x80
+	ExtFlagRSSI        = 0x40
+	ExtFlagRXTimestamp = 0x20
+)
+
+// every part of the extended data, as set with the lib config message
+const extendedDataFlags = ExtFlagChannelID | ExtFlagRSSI | ExtFlagRXTimestamp
+
+// measurement type of an RSSI given in dBm
+const rssiMeasurementDBm = 0x20
+
+// ExtendedData is what the stick appends to a data message when extended
+// messages are enabled. Each part is only there if its Has field is set.
+type ExtendedData struct {
+	HasChannelID bool
+	ChannelID    ChannelID
+
+	HasRSSI bool
+	// RSSI is the signal strength in dBm
+	RSSI int8
+	// Threshold is the proximity search threshold in dBm
+	Threshold int8
+
+	HasRXTimestamp bool
+	// RXTimestamp is when the message came in, in 1/32768s rolling over
+	// every 2s
+	RXTimestamp uint16
+}
+
+// decodeExtendedData reads the flagged extended data following the payload
+// of a data frame. The parts come in flag order, the ones cut short are
+// left out.
+func decodeExtendedData(data []byte) ExtendedData {
+	var ext ExtendedData
+	// the checksum ends the frame
+	end := len(data) - 1
+	if end <= BufferIndexExtMessageBegin {
+		return ext
+	}
+	flags := data[BufferIndexExtMessageBegin]
+	idx := BufferIndexExtMessageBegin + 1
+	if flags&ExtFlagChannelID != 0 {
+		if idx+4 > end {
+			return ext
+		}
+		ext.HasChannelID = true
+		ext.ChannelID = decodeChannelID(data[idx : idx+4])
+		idx += 4
+	}
+	if flags&ExtFlagRSSI != 0 {
+		if idx+3 > end {
+			return ext
+		}
+		if data[idx] == rssiMeasurementDBm {
+			ext.HasRSSI = true
+			ext.RSSI = int8(data[idx+1])
+			ext.Threshold = int8(data[idx+2])
+		}
+		idx += 3
+	}
+	if flags&ExtFlagRXTimestamp != 0 {
+		if idx+2 > end {
+			return ext
+		}
+		ext.HasRXTimestamp = true
+		ext.RXTimestamp = binary.LittleEndian.Uint16(data[idx : idx+2])
+	}
+	return ext
+}
+
+// Signal is how a device's last message was received, as far as its
+// extended data tells. Each part is only there if its Has field is set, a
+// message without it doesn't keep the value of an earlier one.
+type Signal struct {
+	HasRSSI   bool
+	RSSI      int8
+	Threshold int8
+
+	HasRXTimestamp bool
+	RXTimestamp    uint16
+}
+
+func (s *Signal) apply(ext ExtendedData) {
+	*s = Signal{
+		HasRSSI:        ext.HasRSSI,
+		RSSI:           ext.RSSI,
+		Threshold:      ext.Threshold,
+		HasRXTimestamp: ext.HasRXTimestamp,
+		RXTimestamp:    ext.RXTimestamp,
+	}
+}
+
+// extendedDataFor has the stick append every part of the extended data to
+// the data messages of all its channels, sensor's included. It is done once
+// per startup.
+func (drv *BaseDriver) extendedDataFor(sensor *BaseSensor) error {
+	drv.mu.Lock()
+	enabled := drv.extendedData
+	drv.mu.Unlock()
+	if enabled {
+		return nil
+	}
+	for _, msg := range [][]byte{setRxExt(), libConfig(0, extendedDataFlags)} {
+		if err := drv.command(msg); err != nil {
+			return err
+		}
+	}
+	drv.mu.Lock()
+	drv.extendedData = true
+	drv.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,108 @@
+package ant
+
+import (
+	"context"
+	"testing"
+)
+
+func TestExtendedDataOnAnyChannel(t *testing.T) {
+	drv := NewSimulatedDriver()
+	if err := drv.OpenContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer drv.Close()
+
+	first := NewHeartRateSensor(drv)
+	if err := first.Attach(0); err != nil {
+		t.Fatal(err)
+	}
+	second := NewHeartRateSensor(drv)
+	if err := second.AttachConfig(second.ChannelConfig(0).WithExtendedData()); err != nil {
+		t.Fatal(err)
+	}
+	if ch := second.attachedChannel(); ch == nil || *ch != 1 {
+		t.Fatalf("second sensor on channel %v, want 1", ch)
+	}
+	drv.Stick.mu.Lock()
+	libConfig := drv.Stick.libConfig
+	drv.Stick.mu.Unlock()
+	if libConfig != extendedDataFlags {
+		t.Fatalf("lib config 0x%02X, want 0x%02X", libConfig, extendedDataFlags)
+	}
+}
+
+func TestDecodeExtendedData(t *testing.T) {
+	channelID := []byte{0x34, 0x12, 120, 0xA1}
+	rssi := []byte{rssiMeasurementDBm, 0xC4, 0xB0} // -60dBm, threshold -80dBm
+	timestamp := []byte{0x10, 0x27}
+	wantID := ChannelID{DeviceNumber: 0xA1234, DeviceType: 120, TransmissionType: 0xA1}
+	all := byte(ExtFlagChannelID | ExtFlagRSSI | ExtFlagRXTimestamp)
+
+	frame := func(flags byte, ext ...[]byte) []byte {
+		payload := append([]byte{0}, make([]byte, 8)...)
+		payload = append(payload, flags)
+		for _, part := range ext {
+			payload = append(payload, part...)
+		}
+		return buildMessage(payload, MessageChannelBroadcastData)
+	}
+	tests := []struct {
+		name  string
+		frame []byte
+		want  ExtendedData
+	}{
+		{"no extended data", buildMessage(make([]byte, 9), MessageChannelBroadcastData), ExtendedData{}},
+		{"no flags", frame(0), ExtendedData{}},
+		{"channel id", frame(ExtFlagChannelID, channelID),
+			ExtendedData{HasChannelID: true, ChannelID: wantID}},
+		{"rssi", frame(ExtFlagRSSI, rssi),
+			ExtendedData{HasRSSI: true, RSSI: -60, Threshold: -80}},
+		{"timestamp", frame(ExtFlagRXTimestamp, timestamp),
+			ExtendedData{HasRXTimestamp: true, RXTimestamp: 10000}},
+		{"channel id and timestamp", frame(ExtFlagChannelID|ExtFlagRXTimestamp, channelID, timestamp),
+			ExtendedData{HasChannelID: true, ChannelID: wantID, HasRXTimestamp: true, RXTimestamp: 10000}},
+		{"everything", frame(all, channelID, rssi, timestamp),
+			ExtendedData{HasChannelID: true, ChannelID: wantID, HasRSSI: true, RSSI: -60,
+				Threshold: -80, HasRXTimestamp: true, RXTimestamp: 10000}},
+		{"rssi in another unit", frame(ExtFlagRSSI|ExtFlagRXTimestamp, []byte{0x10, 0xC4, 0xB0}, timestamp),
+			ExtendedData{HasRXTimestamp: true, RXTimestamp: 10000}},
+		{"truncated timestamp", frame(ExtFlagChannelID|ExtFlagRXTimestamp, channelID, timestamp[:1]),
+			ExtendedData{HasChannelID: true, ChannelID: wantID}},
+		{"truncated channel id", frame(all, channelID[:3]), ExtendedData{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decodeExtendedData(tt.frame); got != tt.want {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignalOfLastMessage(t *testing.T) {
+	hr := NewHeartRateSensor(NewBaseDriver())
+	var got []Signal
+	hr.ListenForData(func(s *HeartRateSensorState) { got = append(got, s.Signal) })
+	page := append([]byte{0}, heartRatePage(70)...)
+	withRSSI := append(append(page[:9:9], ExtFlagRSSI|ExtFlagRXTimestamp),
+		rssiMeasurementDBm, 0xC4, 0xB0, 0x10, 0x27)
+	withoutRSSI := append(append(page[:9:9], ExtFlagRXTimestamp), 0x20, 0x4E)
+
+	for _, payload := range [][]byte{withRSSI, withoutRSSI, page} {
+		frame := buildMessage(payload, MessageChannelBroadcastData)
+		hr.updateState(ChannelID{}, frame, decodeExtendedData(frame))
+	}
+	want := []Signal{
+		{HasRSSI: true, RSSI: -60, Threshold: -80, HasRXTimestamp: true, RXTimestamp: 10000},
+		{HasRXTimestamp: true, RXTimestamp: 20000},
+		{},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d states, want %d", len(got), len(want))
+	}
+	for idx := range want {
+		if got[idx] != want[idx] {
+			t.Errorf("message %d: got %+v, want %+v", idx, got[idx], want[idx])
+		}
+	}
+}
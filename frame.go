@@ -0,0 +1,53 @@
+package ant
+
+// largest payload the decoder accepts, a flagged extended data message is
+// well below this
+const maxFramePayload = 40
+
+// frameDecoder reassembles frames out of a byte stream. Bytes that can't be
+// part of a valid frame are skipped until the next sync byte, so a corrupt
+// packet costs us that frame and nothing more.
+type frameDecoder struct {
+	buf []byte
+}
+
+// feed adds data to the stream and returns every complete frame found along
+// with the number of bytes thrown away while looking for them.
+func (d *frameDecoder) feed(data []byte) ([][]byte, int) {
+	d.buf = append(d.buf, data...)
+	var frames [][]byte
+	discarded := 0
+	for {
+		start := 0
+		for start < len(d.buf) && d.buf[start] != MessageTXSync {
+			start++
+		}
+		discarded += start
+		d.buf = d.buf[start:]
+		if len(d.buf) < 2 {
+			break
+		}
+		length := int(d.buf[BufferIndexMessageLength])
+		if length > maxFramePayload {
+			// not a real sync byte
+			d.buf = d.buf[1:]
+			discarded++
+			continue
+		}
+		end := length + 4
+		if len(d.buf) < end {
+			break
+		}
+		frame := d.buf[:end]
+		if byte(getChecksum(frame[:end-1])) != frame[end-1] {
+			d.buf = d.buf[1:]
+			discarded++
+			continue
+		}
+		frames = append(frames, append([]byte{}, frame...))
+		d.buf = d.buf[end:]
+	}
+	// don't keep growing the backing array while we only hold a partial frame
+	d.buf = append([]byte{}, d.buf...)
+	return frames, discarded
+}
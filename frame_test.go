@@ -0,0 +1,86 @@
+package ant
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFrameDecoderFeed(t *testing.T) {
+	startup := buildMessage([]byte{0x20}, MessageStartup)
+	event := buildMessage([]byte{1, MessageRF, EventRXFailed}, MessageChannelEvent)
+	corrupt := append([]byte{}, event...)
+	corrupt[len(corrupt)-1] ^= 0x55
+	// a sync byte followed by a length no frame can have
+	bogus := []byte{MessageTXSync, maxFramePayload + 1}
+
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+
+	tests := []struct {
+		name      string
+		feeds     [][]byte
+		want      [][]byte
+		discarded int
+	}{
+		{
+			name:  "one frame",
+			feeds: [][]byte{startup},
+			want:  [][]byte{startup},
+		},
+		{
+			name:  "back to back",
+			feeds: [][]byte{join(startup, event)},
+			want:  [][]byte{startup, event},
+		},
+		{
+			name:      "resync after garbage",
+			feeds:     [][]byte{join([]byte{0x00, 0x13}, startup)},
+			want:      [][]byte{startup},
+			discarded: 2,
+		},
+		{
+			name:      "bad checksum",
+			feeds:     [][]byte{join(corrupt, startup)},
+			want:      [][]byte{startup},
+			discarded: len(corrupt),
+		},
+		{
+			name:  "split frame",
+			feeds: [][]byte{event[:2], event[2:5], event[5:]},
+			want:  [][]byte{event},
+		},
+		{
+			name:      "bogus length",
+			feeds:     [][]byte{join(bogus, event)},
+			want:      [][]byte{event},
+			discarded: len(bogus),
+		},
+		{
+			name:  "partial frame waits",
+			feeds: [][]byte{join(startup, event[:4])},
+			want:  [][]byte{startup},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var d frameDecoder
+			var got [][]byte
+			discarded := 0
+			for _, data := range tt.feeds {
+				frames, n := d.feed(data)
+				got = append(got, frames...)
+				discarded += n
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d frames, want %d", len(got), len(tt.want))
+			}
+			for idx := range got {
+				if !bytes.Equal(got[idx], tt.want[idx]) {
+					t.Errorf("frame %d is % X, want % X", idx, got[idx], tt.want[idx])
+				}
+			}
+			if discarded != tt.discarded {
+				t.Errorf("discarded %d bytes, want %d", discarded, tt.discarded)
+			}
+		})
+	}
+}
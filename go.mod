@@ -1,3 +1,5 @@
 module github.com/MattSwanson/ant-go
 
 go 1.16
+
+require github.com/google/gousb v1.1.3
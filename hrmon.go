@@ -1,10 +1,17 @@
 package ant
 
 import (
+	"context"
 	"encoding/binary"
+	"sync"
 )
 
 
+const (
+	HeartRateSensorDeviceType = 120
+	HeartRateSensorPeriod     = 8070
+)
+
 const (
 	InitPage PageState = 0
 	StdPage PageState = 1
@@ -15,6 +22,8 @@ const (
 
 type HeartRateSensorState struct {
 	DeviceID          uint32
+	ChannelID         ChannelID
+	Signal
 	BeatTime          uint16
 	BeatCount         byte
 	ComputedHeartRate byte
@@ -49,7 +58,7 @@ func (s *HeartRateSensorState) update(page *Page, data []byte) {
 				s.OperatingTime *= 2
 			case 2:
 				s.ManID = data[BufferIndexMessageData+1]
-				s.SerialNumber = uint32(s.DeviceID)
+				s.SerialNumber = s.DeviceID & 0xFFFF
 				s.SerialNumber |= uint32(binary.LittleEndian.Uint16(data[BufferIndexMessageData+2:BufferIndexMessageData+4])) << 16
 				s.SerialNumber ^= 0x80000000
 			case 3:
@@ -101,16 +110,23 @@ func (s *HeartRateSensorState) update(page *Page, data []byte) {
 
 type HeartRateScannerState struct {
 	*HeartRateSensorState
-	RSSI      uint32
-	Threshold uint32
+}
+
+// copy returns a copy that doesn't share the sensor state.
+func (state *HeartRateScannerState) copy() *HeartRateScannerState {
+	sensorState := *state.HeartRateSensorState
+	c := *state
+	c.HeartRateSensorState = &sensorState
+	return &c
 }
 
 type HeartRateSensor struct {
 //TODO do
 	*AntPlusSensor
+	mu sync.Mutex
 	state *HeartRateSensorState
 	page *Page
-	listeners []func(*HeartRateSensorState)
+	listeners listenerList
 }
 
 func NewHeartRateSensor(driver Driver) *HeartRateSensor {
@@ -122,23 +138,59 @@ func NewHeartRateSensor(driver Driver) *HeartRateSensor {
 	return &hrs
 }
 
-func (sensor *HeartRateSensor) updateState(deviceID uint32, data []byte) {
+func (sensor *HeartRateSensor) updateState(id ChannelID, data []byte, ext ExtendedData) {
+	sensor.mu.Lock()
+	sensor.state.DeviceID = id.DeviceNumber
+	sensor.state.ChannelID = id
+	sensor.state.Signal.apply(ext)
 	sensor.state.update(sensor.page, data)
-	for _, cb := range sensor.listeners {
-		cb(sensor.state)
+	state := *sensor.state
+	sensor.mu.Unlock()
+	for _, cb := range sensor.listeners.get() {
+		state := state
+		cb(&state)
 	}
 }
 
-func (sensor *HeartRateSensor) ListenForData(cb func(*HeartRateSensorState)) {
-	sensor.listeners = append(sensor.listeners, cb)
+// ListenForData registers cb to be called with its own copy of the state
+// every time it is updated, and returns the func removing it. It can be
+// called while data is coming in. cb runs on the driver's reader goroutine,
+// see Subscribe for a consumer that may fall behind.
+func (sensor *HeartRateSensor) ListenForData(cb func(*HeartRateSensorState)) func() {
+	return sensor.listeners.add(func(state interface{}) {
+		cb(state.(*HeartRateSensorState))
+	})
+}
+
+// Subscribe returns a channel receiving a copy of the state every time it is
+// updated, closed once ctx is done. Reading from the stick never waits for
+// the receiver, states it is too slow for are dropped as opts say.
+func (sensor *HeartRateSensor) Subscribe(ctx context.Context, opts SubscribeOptions) <-chan *HeartRateSensorState {
+	ch := make(chan *HeartRateSensorState, opts.buffer())
+	sensor.listeners.subscribe(ctx, ch, opts)
+	return ch
+}
+
+// ChannelConfig returns the channel config Attach uses for deviceID, to be
+// adjusted and passed to AttachConfig.
+func (sensor *HeartRateSensor) ChannelConfig(deviceID uint32) ChannelConfig {
+	return sensor.profileConfig(HeartRateSensorDeviceType, HeartRateSensorPeriod, deviceID)
+}
+
+// Attach opens a channel to the heart rate monitor with deviceID, zero pairs
+// with the first one found. Like AttachConfig, it can't be called from a
+// callback.
+func (sensor *HeartRateSensor) Attach(deviceID uint32) error {
+	return sensor.AttachConfig(sensor.ChannelConfig(deviceID))
 }
 
 
 type HeartRateScanner struct {
 	*AntPlusScanner
+	mu sync.Mutex
 	states map[uint32]*HeartRateScannerState
 	pages map[uint32]*Page
-	listeners []func(*HeartRateScannerState)
+	listeners listenerList
 }
 
 func NewHeartRateScannerState(deviceID uint32) *HeartRateScannerState {
@@ -166,10 +218,12 @@ func NewHeartRateScanner(driver Driver) *HeartRateScanner {
 }
 
 func (s *HeartRateScanner) deviceType() uint32 {
-	return 120
+	return HeartRateSensorDeviceType
 }
 
 func (s *HeartRateScanner) createStateIfNew(deviceID uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if _, ok := s.states[deviceID]; !ok {
 		s.states[deviceID] = NewHeartRateScannerState(deviceID)
 	}
@@ -178,18 +232,33 @@ func (s *HeartRateScanner) createStateIfNew(deviceID uint32) {
 	}
 }
 
-func (s *HeartRateScanner) ListenForData(cb func(*HeartRateScannerState)) {
-	s.listeners = append(s.listeners, cb)
+// ListenForData registers cb to be called with its own copy of a device's
+// state every time it is updated, and returns the func removing it. It can
+// be called while scanning.
+func (s *HeartRateScanner) ListenForData(cb func(*HeartRateScannerState)) func() {
+	return s.listeners.add(func(state interface{}) {
+		cb(state.(*HeartRateScannerState))
+	})
 }
 
-func (s *HeartRateScanner) updateRssiAndThreshold(deviceID, rssi, threshold uint32) {
-	s.states[deviceID].RSSI = rssi
-	s.states[deviceID].Threshold = threshold
+// Subscribe returns a channel receiving a copy of a device's state every
+// time it is updated, closed once ctx is done. States the receiver is too
+// slow for are dropped as opts say.
+func (s *HeartRateScanner) Subscribe(ctx context.Context, opts SubscribeOptions) <-chan *HeartRateScannerState {
+	ch := make(chan *HeartRateScannerState, opts.buffer())
+	s.listeners.subscribe(ctx, ch, opts)
+	return ch
 }
 
-func (s *HeartRateScanner) updateState(deviceID uint32, data []byte) {
+func (s *HeartRateScanner) updateState(id ChannelID, data []byte, ext ExtendedData) {
+	deviceID := id.DeviceNumber
+	s.mu.Lock()
+	s.states[deviceID].ChannelID = id
+	s.states[deviceID].Signal.apply(ext)
 	s.states[deviceID].update(s.pages[deviceID], data)
-	for _, cb := range s.listeners {
-		cb(s.states[deviceID])
+	state := s.states[deviceID].copy()
+	s.mu.Unlock()
+	for _, cb := range s.listeners.get() {
+		cb(state.copy())
 	}
 }
@@ -0,0 +1,129 @@
+package ant
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("LEVEL(%d)", int(l))
+}
+
+// Field is a key and value attached to a log entry, like the channel or
+// message id it is about.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Logger receives everything the drivers and sensors have to say. Drivers
+// are silent until one is set with SetLogger.
+type Logger interface {
+	Log(level Level, msg string, fields ...Field)
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Log(Level, string, ...Field) {}
+
+type stdLogger struct {
+	l   *log.Logger
+	min Level
+}
+
+// NewStdLogger returns a Logger writing entries at or above min to l, as
+// "LEVEL msg key=value ...". A nil l uses the standard logger.
+func NewStdLogger(l *log.Logger, min Level) Logger {
+	if l == nil {
+		l = log.Default()
+	}
+	return &stdLogger{l: l, min: min}
+}
+
+func (s *stdLogger) Log(level Level, msg string, fields ...Field) {
+	if level < s.min {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(level.String())
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for _, f := range fields {
+		fmt.Fprintf(&b, " %s=%v", f.Key, f.Value)
+	}
+	s.l.Print(b.String())
+}
+
+// fields commonly attached to entries
+func channelField(channel uint8) Field { return Field{"channel", channel} }
+
+func messageField(msgID byte) Field { return Field{"messageID", fmt.Sprintf("0x%02X", msgID)} }
+
+func deviceField(deviceID uint32) Field { return Field{"deviceID", deviceID} }
+
+func errorField(err error) Field { return Field{"error", err} }
+
+func frameField(frame []byte) Field { return Field{"frame", fmt.Sprintf("% X", frame)} }
+
+// FrameDirection tells whether a traced frame was read from or written to
+// the stick.
+type FrameDirection int
+
+const (
+	FrameRX FrameDirection = iota
+	FrameTX
+)
+
+func (d FrameDirection) String() string {
+	if d == FrameTX {
+		return captureTX
+	}
+	return captureRX
+}
+
+// Frame is a complete ANT message as it went over the transport.
+type Frame struct {
+	Direction FrameDirection
+	MessageID byte
+	// Payload is what's between the message id and the checksum, the
+	// channel number comes first for channel messages
+	Payload []byte
+	// Raw is the whole frame, sync byte and checksum included
+	Raw []byte
+}
+
+// TraceFunc is called with every frame read from or written to the stick.
+// It runs on the goroutine doing the reading or writing and must not keep
+// Raw or Payload past the call.
+type TraceFunc func(Frame)
+
+func newFrame(direction FrameDirection, data []byte) Frame {
+	frame := Frame{Direction: direction, Raw: data}
+	if len(data) > BufferIndexMessageType {
+		frame.MessageID = data[BufferIndexMessageType]
+	}
+	if len(data) > BufferIndexMessageType+1 {
+		frame.Payload = data[BufferIndexMessageType+1 : len(data)-1]
+	}
+	return frame
+}
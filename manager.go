@@ -0,0 +1,343 @@
+package ant
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/google/gousb"
+)
+
+// StickManager opens every stick with a given vendor and product id and
+// pools their channels behind a single Driver. Each sensor attaching is
+// placed on the stick with the most free channels. It is safe for
+// concurrent use.
+type StickManager struct {
+	vendorID  gousb.ID
+	productID gousb.ID
+
+	// DedicateScanStick keeps the first stick able to scan for scanning so
+	// the others are left to sensors tracking paired devices. It has no
+	// effect with a single stick or none able to scan and must be set
+	// before Open.
+	DedicateScanStick bool
+
+	startupCallbacks []func()
+
+	mu         sync.Mutex
+	log        Logger
+	trace      TraceFunc
+	keys       map[uint8]NetworkKey
+	sticks     []*USBDriver
+	scanStick  *USBDriver
+	placements map[*BaseSensor]*USBDriver
+}
+
+func NewStickManager(vendorID, productID gousb.ID) *StickManager {
+	return &StickManager{
+		vendorID:   vendorID,
+		productID:  productID,
+		placements: make(map[*BaseSensor]*USBDriver),
+	}
+}
+
+func (m *StickManager) OnStartup(fn func()) {
+	m.startupCallbacks = append(m.startupCallbacks, fn)
+}
+
+// SetLogger sends what the manager, its sticks and sensors log to l. Set it
+// before Open for the sticks to use it.
+func (m *StickManager) SetLogger(l Logger) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.log = l
+}
+
+// SetTrace has fn called with every frame going through any of the sticks.
+// Set it before Open.
+func (m *StickManager) SetTrace(fn TraceFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.trace = fn
+}
+
+// SetNetworkKey registers key on network for every stick, see
+// BaseDriver.SetNetworkKey. It must be called before Open.
+func (m *StickManager) SetNetworkKey(network uint8, key NetworkKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.sticks) > 0 {
+		return ErrDriverOpen
+	}
+	if m.keys == nil {
+		m.keys = make(map[uint8]NetworkKey)
+	}
+	m.keys[network] = key
+	return nil
+}
+
+func (m *StickManager) logger() Logger {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.log == nil {
+		return nopLogger{}
+	}
+	return m.log
+}
+
+// Open is OpenContext bounded by DefaultStartupTimeout.
+func (m *StickManager) Open(usb *gousb.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultStartupTimeout)
+	defer cancel()
+	return m.OpenContext(ctx, usb)
+}
+
+// OpenContext claims every free matching stick and waits for all of them to
+// be ready. A stick that fails to start is logged and left alone, the error
+// is only returned if no stick at all could be opened.
+func (m *StickManager) OpenContext(ctx context.Context, usb *gousb.Context) error {
+	return m.openSticks(ctx, func(stick *USBDriver, skip []stickLocation) (*stickLocation, error) {
+		return stick.open(ctx, usb, 0, skip)
+	})
+}
+
+// openSticks opens sticks with open until there is none left, skipping the
+// ones that failed to start.
+func (m *StickManager) openSticks(ctx context.Context,
+	open func(*USBDriver, []stickLocation) (*stickLocation, error)) error {
+	if len(m.Sticks()) > 0 {
+		return ErrDriverOpen
+	}
+	m.mu.Lock()
+	logger, trace, keys := m.log, m.trace, m.keys
+	m.mu.Unlock()
+	if logger == nil {
+		logger = nopLogger{}
+	}
+	var sticks []*USBDriver
+	var failed []stickLocation
+	var firstErr error
+	for ctx.Err() == nil {
+		stick := NewUSBDriver(m.vendorID, m.productID)
+		m.watch(stick)
+		stick.SetLogger(logger)
+		stick.SetTrace(trace)
+		for network, key := range keys {
+			stick.SetNetworkKey(network, key)
+		}
+		loc, err := open(stick, failed)
+		if err == nil {
+			sticks = append(sticks, stick)
+			continue
+		}
+		if loc == nil {
+			// nothing left to claim, a stick that failed to start
+			// tells more than ErrNoStick
+			if err != ErrNoStick || firstErr == nil {
+				firstErr = err
+			}
+			break
+		}
+		logger.Log(LevelWarn, "stick did not start", errorField(err))
+		failed = append(failed, *loc)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if len(sticks) == 0 {
+		if firstErr == nil {
+			firstErr = ctx.Err()
+		}
+		return firstErr
+	}
+	m.mu.Lock()
+	m.sticks = sticks
+	if m.DedicateScanStick && len(sticks) > 1 {
+		for _, stick := range sticks {
+			if stick.canScan() {
+				m.scanStick = stick
+				break
+			}
+		}
+	}
+	m.mu.Unlock()
+	for _, cb := range m.startupCallbacks {
+		cb()
+	}
+	return nil
+}
+
+// Sticks returns the drivers of every stick the manager opened.
+func (m *StickManager) Sticks() []*USBDriver {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*USBDriver{}, m.sticks...)
+}
+
+func (m *StickManager) Close() {
+	m.mu.Lock()
+	sticks := m.sticks
+	m.sticks = nil
+	m.scanStick = nil
+	m.mu.Unlock()
+	for _, stick := range sticks {
+		stick.Close()
+	}
+}
+
+// watch forgets which sensors were placed on stick whenever it goes away,
+// they are attached again through the manager once it is back.
+func (m *StickManager) watch(stick *USBDriver) {
+	stick.OnDisconnect(func(error) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		for sensor, placed := range m.placements {
+			if placed == stick {
+				delete(m.placements, sensor)
+			}
+		}
+	})
+}
+
+// pick returns the sticks a new sensor could go on, best first.
+func (m *StickManager) pick(forScan bool) []*USBDriver {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if forScan {
+		if m.scanStick != nil {
+			return []*USBDriver{m.scanStick}
+		}
+		for _, stick := range m.sticks {
+			if stick.canScan() && stick.idle() {
+				return []*USBDriver{stick}
+			}
+		}
+		return nil
+	}
+	var sticks []*USBDriver
+	for _, stick := range m.sticks {
+		if stick != m.scanStick {
+			sticks = append(sticks, stick)
+		}
+	}
+	sort.SliceStable(sticks, func(i, j int) bool {
+		return sticks[i].freeChannels() > sticks[j].freeChannels()
+	})
+	return sticks
+}
+
+func (m *StickManager) place(sensor *BaseSensor, stick *USBDriver) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.placements[sensor] = stick
+}
+
+func (m *StickManager) placement(sensor *BaseSensor) *USBDriver {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.placements[sensor]
+}
+
+// attach puts sensor on the best stick that has a channel free.
+func (m *StickManager) attach(sensor *BaseSensor, forScan bool) (uint8, error) {
+	m.mu.Lock()
+	if _, ok := m.placements[sensor]; ok {
+		m.mu.Unlock()
+		return 0, ErrAlreadyAttached
+	}
+	// hold the sensor's place while looking for a stick
+	m.placements[sensor] = nil
+	m.mu.Unlock()
+
+	err := ErrCannotAttach
+	for _, stick := range m.pick(forScan) {
+		var channel uint8
+		if channel, err = stick.attach(sensor, forScan); err == nil {
+			m.place(sensor, stick)
+			return channel, nil
+		}
+	}
+	m.mu.Lock()
+	delete(m.placements, sensor)
+	m.mu.Unlock()
+	return 0, err
+}
+
+func (m *StickManager) appendScanner(sensor *BaseSensor) {
+	for _, stick := range m.Sticks() {
+		if stick.isScanning() {
+			stick.appendScanner(sensor)
+			m.place(sensor, stick)
+			return
+		}
+	}
+}
+
+func (m *StickManager) leaveScan(sensor *BaseSensor) bool {
+	stick := m.placement(sensor)
+	if stick == nil || !stick.leaveScan(sensor) {
+		return false
+	}
+	m.mu.Lock()
+	delete(m.placements, sensor)
+	m.mu.Unlock()
+	return true
+}
+
+func (m *StickManager) detach(sensor *BaseSensor) bool {
+	m.mu.Lock()
+	stick, ok := m.placements[sensor]
+	delete(m.placements, sensor)
+	m.mu.Unlock()
+	if !ok || stick == nil {
+		return false
+	}
+	return stick.detach(sensor)
+}
+
+func (m *StickManager) canScan() bool {
+	m.mu.Lock()
+	scanStick := m.scanStick
+	m.mu.Unlock()
+	if scanStick != nil {
+		return scanStick.canScan()
+	}
+	for _, stick := range m.Sticks() {
+		if stick.canScan() {
+			return true
+		}
+	}
+	return false
+}
+
+// stopped is true once the manager is closed, its sticks reconnect on their
+// own until then.
+func (m *StickManager) stopped() bool {
+	return len(m.Sticks()) == 0
+}
+
+func (m *StickManager) isScanning() bool {
+	for _, stick := range m.Sticks() {
+		if stick.isScanning() {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *StickManager) extendedDataFor(sensor *BaseSensor) error {
+	stick := m.placement(sensor)
+	if stick == nil {
+		return errors.New("sensor is not attached to any stick")
+	}
+	return stick.extendedDataFor(sensor)
+}
+
+func (m *StickManager) writeFor(sensor *BaseSensor, data []byte) error {
+	stick := m.placement(sensor)
+	if stick == nil {
+		return errors.New("sensor is not attached to any stick")
+	}
+	return stick.writeFor(sensor, data)
+}
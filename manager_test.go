@@ -0,0 +1,139 @@
+package ant
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStickManagerReattachesAfterReplug(t *testing.T) {
+	m := NewStickManager(0, 0)
+	defer m.Close()
+	sims := make(map[*USBDriver]*SimulatedStick)
+	for i := 0; i < 2; i++ {
+		sim := NewSimulatedStick()
+		stick := NewUSBDriver(0, 0)
+		stick.enableReconnect(10*time.Millisecond, func() (Transport, error) {
+			return sim.reopen(), nil
+		})
+		m.watch(stick)
+		if err := stick.StartContext(context.Background(), sim.reopen()); err != nil {
+			t.Fatal(err)
+		}
+		m.sticks = append(m.sticks, stick)
+		sims[stick] = sim
+	}
+
+	hr := NewHeartRateSensor(m)
+	if err := hr.Attach(0); err != nil {
+		t.Fatal(err)
+	}
+	stick := m.placement(&hr.BaseSensor)
+	if stick == nil {
+		t.Fatal("sensor not placed on a stick")
+	}
+	reconnected := make(chan struct{}, 1)
+	stick.OnReconnect(func() { reconnected <- struct{}{} })
+	sims[stick].Close()
+
+	select {
+	case <-reconnected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stick did not reconnect")
+	}
+	if hr.attachedChannel() == nil {
+		t.Fatal("sensor not attached again after the stick came back")
+	}
+	if m.placement(&hr.BaseSensor) == nil {
+		t.Fatal("sensor attached again but not placed on a stick")
+	}
+}
+
+// fakeBus hands out simulated sticks like openUSBTransport would, the ones
+// in broken fail to start.
+type fakeBus struct {
+	sims    []*SimulatedStick
+	broken  map[int]bool
+	claimed map[int]bool
+}
+
+var errNoAnswer = errors.New("stick did not answer")
+
+func (b *fakeBus) open(stick *USBDriver, skip []stickLocation) (*stickLocation, error) {
+	for idx, sim := range b.sims {
+		loc := stickLocation{bus: 1, address: idx}
+		if b.claimed[idx] || contains(skip, loc) {
+			continue
+		}
+		if b.broken[idx] {
+			return &loc, errNoAnswer
+		}
+		b.claimed[idx] = true
+		return &loc, stick.StartContext(context.Background(), sim.reopen())
+	}
+	return nil, ErrNoStick
+}
+
+func contains(locs []stickLocation, loc stickLocation) bool {
+	for _, l := range locs {
+		if l == loc {
+			return true
+		}
+	}
+	return false
+}
+
+func TestStickManagerOpensEveryStick(t *testing.T) {
+	bus := &fakeBus{broken: map[int]bool{0: true}, claimed: map[int]bool{}}
+	for i := 0; i < 4; i++ {
+		bus.sims = append(bus.sims, NewSimulatedStick())
+		bus.sims[i].SerialNumber = uint32(i)
+	}
+	bus.sims[1].NoScan = true
+
+	m := NewStickManager(0, 0)
+	m.DedicateScanStick = true
+	if err := m.openSticks(context.Background(), bus.open); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	sticks := m.Sticks()
+	if len(sticks) != 3 {
+		t.Fatalf("opened %d sticks, want the 3 that started", len(sticks))
+	}
+	if m.scanStick == nil || m.scanStick.StickInfo().SerialNumber != 2 {
+		t.Errorf("scan stick is %v, want the first one able to scan", m.scanStick)
+	}
+}
+
+func TestStickManagerOpenFailures(t *testing.T) {
+	m := NewStickManager(0, 0)
+	bus := &fakeBus{claimed: map[int]bool{}}
+	if err := m.openSticks(context.Background(), bus.open); err != ErrNoStick {
+		t.Errorf("without sticks: got %v, want ErrNoStick", err)
+	}
+	bus = &fakeBus{sims: []*SimulatedStick{NewSimulatedStick()}, broken: map[int]bool{0: true},
+		claimed: map[int]bool{}}
+	if err := m.openSticks(context.Background(), bus.open); err != errNoAnswer {
+		t.Errorf("with a broken stick: got %v, want its error", err)
+	}
+}
+
+func TestStickManagerNoScanStick(t *testing.T) {
+	bus := &fakeBus{claimed: map[int]bool{}}
+	for i := 0; i < 2; i++ {
+		sim := NewSimulatedStick()
+		sim.NoScan = true
+		bus.sims = append(bus.sims, sim)
+	}
+	m := NewStickManager(0, 0)
+	m.DedicateScanStick = true
+	if err := m.openSticks(context.Background(), bus.open); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if m.scanStick != nil {
+		t.Error("dedicated a stick that can't scan")
+	}
+}
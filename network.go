@@ -0,0 +1,65 @@
+package ant
+
+// NetworkKey is the 8 byte key a network is set up with. Only devices using
+// the same key on the same frequency can be heard.
+type NetworkKey [8]byte
+
+var (
+	// AntPlusNetworkKey is used by every ANT+ device profile. The driver
+	// sets it on DefaultNetworkNumber unless told otherwise.
+	AntPlusNetworkKey = NetworkKey{0xB9, 0xA5, 0x21, 0xFB, 0xBD, 0x72, 0xC3, 0x45}
+	// AntFSNetworkKey is used by ANT-FS devices
+	AntFSNetworkKey = NetworkKey{0xA8, 0xA4, 0x23, 0xB9, 0xF5, 0x5E, 0x63, 0xC1}
+	// PublicNetworkKey is the key of the public network
+	PublicNetworkKey = NetworkKey{}
+)
+
+// SetNetworkKey registers key to be set on network when the stick starts up,
+// and again whenever it reconnects. It must be called before the driver is
+// opened. Setting a key on DefaultNetworkNumber replaces the ANT+ key there,
+// see BaseSensor.SetNetwork to put a sensor on another network.
+func (drv *BaseDriver) SetNetworkKey(network uint8, key NetworkKey) error {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+	if drv.running {
+		return ErrDriverOpen
+	}
+	if drv.networkKeys == nil {
+		drv.networkKeys = make(map[uint8]NetworkKey)
+	}
+	drv.networkKeys[network] = key
+	return nil
+}
+
+// networkKeyAfter returns the message setting the key of the first network
+// above after, nil once there are no more keys to set.
+func (drv *BaseDriver) networkKeyAfter(after int) []byte {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+	for network := after + 1; network <= 0xFF; network++ {
+		key, ok := drv.networkKeys[uint8(network)]
+		if !ok && network == DefaultNetworkNumber {
+			key, ok = AntPlusNetworkKey, true
+		}
+		if ok {
+			return setNetworkKey(uint8(network), key)
+		}
+	}
+	return nil
+}
+
+// SetNetwork picks the network the sensor's channel is assigned to whenever
+// the sensor builds the ChannelConfig itself, as it does for scans,
+// DefaultNetworkNumber otherwise. The network's key has to be registered
+// with the driver first.
+func (sensor *BaseSensor) SetNetwork(network uint8) {
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
+	sensor.network = network
+}
+
+func (sensor *BaseSensor) networkNumber() uint8 {
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
+	return sensor.network
+}
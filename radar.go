@@ -1,7 +1,14 @@
 package ant
 
 import (
+	"context"
 	"encoding/binary"
+	"sync"
+)
+
+const (
+	BikeRadarSensorDeviceType = 0x28
+	BikeRadarSensorPeriod     = 8192
 )
 
 type Target struct {
@@ -13,6 +20,8 @@ type Target struct {
 
 type BikeRadarSensorState struct {
 	DeviceID          uint32
+	ChannelID         ChannelID
+	Signal
 	OperatingTime     uint32
 	ManID             uint16
 	SerialNumber      uint32
@@ -133,16 +142,23 @@ func (s *BikeRadarSensorState) update(page *Page, data []byte) {
 
 type BikeRadarScannerState struct {
 	*BikeRadarSensorState
-	RSSI      uint32
-	Threshold uint32
+}
+
+// copy returns a copy that doesn't share the sensor state.
+func (state *BikeRadarScannerState) copy() *BikeRadarScannerState {
+	sensorState := *state.BikeRadarSensorState
+	c := *state
+	c.BikeRadarSensorState = &sensorState
+	return &c
 }
 
 type BikeRadarSensor struct {
 	//TODO do
 	*AntPlusSensor
+	mu sync.Mutex
 	state     *BikeRadarSensorState
 	page      *Page
-	listeners []func(*BikeRadarSensorState)
+	listeners listenerList
 }
 
 func NewBikeRadarSensor(driver Driver) *BikeRadarSensor {
@@ -154,22 +170,58 @@ func NewBikeRadarSensor(driver Driver) *BikeRadarSensor {
 	return &hrs
 }
 
-func (sensor *BikeRadarSensor) updateState(deviceID uint32, data []byte) {
+func (sensor *BikeRadarSensor) updateState(id ChannelID, data []byte, ext ExtendedData) {
+	sensor.mu.Lock()
+	sensor.state.DeviceID = id.DeviceNumber
+	sensor.state.ChannelID = id
+	sensor.state.Signal.apply(ext)
 	sensor.state.update(sensor.page, data)
-	for _, cb := range sensor.listeners {
-		cb(sensor.state)
+	state := *sensor.state
+	sensor.mu.Unlock()
+	for _, cb := range sensor.listeners.get() {
+		state := state
+		cb(&state)
 	}
 }
 
-func (sensor *BikeRadarSensor) ListenForData(cb func(*BikeRadarSensorState)) {
-	sensor.listeners = append(sensor.listeners, cb)
+// ListenForData registers cb to be called with its own copy of the state
+// every time it is updated, and returns the func removing it. It can be
+// called while data is coming in. cb runs on the driver's reader goroutine,
+// see Subscribe for a consumer that may fall behind.
+func (sensor *BikeRadarSensor) ListenForData(cb func(*BikeRadarSensorState)) func() {
+	return sensor.listeners.add(func(state interface{}) {
+		cb(state.(*BikeRadarSensorState))
+	})
+}
+
+// Subscribe returns a channel receiving a copy of the state every time it is
+// updated, closed once ctx is done. Reading from the stick never waits for
+// the receiver, states it is too slow for are dropped as opts say.
+func (sensor *BikeRadarSensor) Subscribe(ctx context.Context, opts SubscribeOptions) <-chan *BikeRadarSensorState {
+	ch := make(chan *BikeRadarSensorState, opts.buffer())
+	sensor.listeners.subscribe(ctx, ch, opts)
+	return ch
+}
+
+// ChannelConfig returns the channel config Attach uses for deviceID, to be
+// adjusted and passed to AttachConfig.
+func (sensor *BikeRadarSensor) ChannelConfig(deviceID uint32) ChannelConfig {
+	return sensor.profileConfig(BikeRadarSensorDeviceType, BikeRadarSensorPeriod, deviceID)
+}
+
+// Attach opens a channel to the bike radar with deviceID, zero pairs with
+// the first one found. Like AttachConfig, it can't be called from a
+// callback.
+func (sensor *BikeRadarSensor) Attach(deviceID uint32) error {
+	return sensor.AttachConfig(sensor.ChannelConfig(deviceID))
 }
 
 type BikeRadarScanner struct {
 	*AntPlusScanner
+	mu sync.Mutex
 	states    map[uint32]*BikeRadarScannerState
 	pages     map[uint32]*Page
-	listeners []func(*BikeRadarScannerState)
+	listeners listenerList
 }
 
 func NewBikeRadarScannerState(deviceID uint32) *BikeRadarScannerState {
@@ -190,10 +242,12 @@ func NewBikeRadarScanner(driver Driver) *BikeRadarScanner {
 }
 
 func (s *BikeRadarScanner) deviceType() uint32 {
-	return 0x28
+	return BikeRadarSensorDeviceType
 }
 
 func (s *BikeRadarScanner) createStateIfNew(deviceID uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if _, ok := s.states[deviceID]; !ok {
 		s.states[deviceID] = NewBikeRadarScannerState(deviceID)
 	}
@@ -202,18 +256,33 @@ func (s *BikeRadarScanner) createStateIfNew(deviceID uint32) {
 	}
 }
 
-func (s *BikeRadarScanner) ListenForData(cb func(*BikeRadarScannerState)) {
-	s.listeners = append(s.listeners, cb)
+// ListenForData registers cb to be called with its own copy of a device's
+// state every time it is updated, and returns the func removing it. It can
+// be called while scanning.
+func (s *BikeRadarScanner) ListenForData(cb func(*BikeRadarScannerState)) func() {
+	return s.listeners.add(func(state interface{}) {
+		cb(state.(*BikeRadarScannerState))
+	})
 }
 
-func (s *BikeRadarScanner) updateRssiAndThreshold(deviceID, rssi, threshold uint32) {
-	s.states[deviceID].RSSI = rssi
-	s.states[deviceID].Threshold = threshold
+// Subscribe returns a channel receiving a copy of a device's state every
+// time it is updated, closed once ctx is done. States the receiver is too
+// slow for are dropped as opts say.
+func (s *BikeRadarScanner) Subscribe(ctx context.Context, opts SubscribeOptions) <-chan *BikeRadarScannerState {
+	ch := make(chan *BikeRadarScannerState, opts.buffer())
+	s.listeners.subscribe(ctx, ch, opts)
+	return ch
 }
 
-func (s *BikeRadarScanner) updateState(deviceID uint32, data []byte) {
+func (s *BikeRadarScanner) updateState(id ChannelID, data []byte, ext ExtendedData) {
+	deviceID := id.DeviceNumber
+	s.mu.Lock()
+	s.states[deviceID].ChannelID = id
+	s.states[deviceID].Signal.apply(ext)
 	s.states[deviceID].update(s.pages[deviceID], data)
-	for _, cb := range s.listeners {
-		cb(s.states[deviceID])
+	state := s.states[deviceID].copy()
+	s.mu.Unlock()
+	for _, cb := range s.listeners.get() {
+		cb(state.copy())
 	}
 }
@@ -0,0 +1,184 @@
+package ant
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// DefaultReacquireBackoff is the first wait before reopening the channel
+	// of a lost device when the policy's Backoff isn't set.
+	DefaultReacquireBackoff = time.Second
+	// DefaultReacquireMaxBackoff caps the wait when MaxBackoff isn't set.
+	DefaultReacquireMaxBackoff = time.Minute
+)
+
+// ReacquirePolicy is how an attached sensor gets its device back once it
+// drops out. The channel is reopened with the ChannelConfig the sensor was
+// attached with, its callbacks and listeners stay in place.
+type ReacquirePolicy struct {
+	// KeepSearching leaves the channel searching when the device drops out,
+	// instead of closing it. Once that search times out the channel is
+	// reopened like any other.
+	KeepSearching bool
+	// Backoff is the wait before reopening a closed channel. It doubles
+	// every time the device isn't found, up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// SearchTimeout and LowPrioritySearchTimeout replace the config's for
+	// the reopened channel, nil keeps them.
+	SearchTimeout            *uint8
+	LowPrioritySearchTimeout *uint8
+	// GiveUpAfter is how long the device may stay lost before the sensor is
+	// detached for good, zero keeps trying.
+	GiveUpAfter time.Duration
+}
+
+func (p *ReacquirePolicy) firstBackoff() time.Duration {
+	if p.Backoff > 0 {
+		return p.Backoff
+	}
+	return DefaultReacquireBackoff
+}
+
+func (p *ReacquirePolicy) maxBackoff() time.Duration {
+	if p.MaxBackoff > 0 {
+		return p.MaxBackoff
+	}
+	return DefaultReacquireMaxBackoff
+}
+
+// config returns cfg with the policy's search timeouts.
+func (p *ReacquirePolicy) config(cfg ChannelConfig) ChannelConfig {
+	if p.SearchTimeout != nil {
+		cfg = cfg.WithSearchTimeout(*p.SearchTimeout)
+	}
+	if p.LowPrioritySearchTimeout != nil {
+		cfg = cfg.WithLowPrioritySearchTimeout(*p.LowPrioritySearchTimeout)
+	}
+	return cfg
+}
+
+// SetReacquirePolicy sets how the sensor gets its device back after losing
+// it. With a nil policy, the default, the sensor is detached as soon as the
+// device drops out. Scanners never lose a device and ignore it.
+func (sensor *BaseSensor) SetReacquirePolicy(policy *ReacquirePolicy) {
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
+	if policy != nil {
+		p := *policy
+		policy = &p
+	}
+	sensor.policy = policy
+	sensor.resetReacquire()
+}
+
+// keepSearching tells if the policy leaves channel searching for the device
+// that just dropped out. Past GiveUpAfter the channel is closed anyway.
+func (sensor *BaseSensor) keepSearching(channel uint32) bool {
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
+	policy := sensor.policy
+	if policy == nil || !policy.KeepSearching {
+		return false
+	}
+	sensor.markLost()
+	if policy.GiveUpAfter > 0 {
+		sensor.schedule(policy.GiveUpAfter-time.Since(sensor.lostAt), func() {
+			// reacquire gives up once the channel is closed
+			sensor.write(closeChannel(channel))
+		})
+	}
+	return true
+}
+
+// reacquire attaches the sensor again with cfg once the backoff is over,
+// after the stick unassigned channel on its own. It gives up past the
+// policy's GiveUpAfter.
+func (sensor *BaseSensor) reacquire(cfg ChannelConfig, channel uint32) {
+	sensor.mu.Lock()
+	policy := sensor.policy
+	if policy == nil {
+		sensor.mu.Unlock()
+		return
+	}
+	sensor.markLost()
+	if policy.GiveUpAfter > 0 && time.Since(sensor.lostAt) >= policy.GiveUpAfter {
+		sensor.resetReacquire()
+		sensor.mu.Unlock()
+		sensor.driver.logger().Log(LevelInfo, "gave up reacquiring device",
+			channelField(uint8(channel)), deviceField(cfg.DeviceID))
+		sensor.emit(SensorLost, channel)
+		return
+	}
+	wait := sensor.backoff
+	if wait <= 0 {
+		wait = policy.firstBackoff()
+	}
+	sensor.backoff = wait * 2
+	if max := policy.maxBackoff(); sensor.backoff > max {
+		sensor.backoff = max
+	}
+	cfg = policy.config(cfg)
+	sensor.schedule(wait, func() {
+		err := sensor.attach(cfg)
+		if err == nil || errors.Is(err, ErrAlreadyAttached) || sensor.driver.stopped() {
+			return
+		}
+		sensor.driver.logger().Log(LevelWarn, "could not reopen channel for lost device",
+			deviceField(cfg.DeviceID), errorField(err))
+		sensor.reacquire(cfg, channel)
+	})
+	sensor.mu.Unlock()
+}
+
+// cancelReacquire stops trying to get the device back, the sensor is being
+// detached.
+func (sensor *BaseSensor) cancelReacquire() {
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
+	sensor.resetReacquire()
+}
+
+// markLost records when the device dropped out, if it isn't lost already.
+// mu must be held.
+func (sensor *BaseSensor) markLost() {
+	if sensor.lostAt.IsZero() {
+		sensor.lostAt = time.Now()
+	}
+}
+
+// resetReacquire forgets about the last loss, the next one starts over with
+// the first backoff. mu must be held.
+func (sensor *BaseSensor) resetReacquire() {
+	sensor.stopRetry()
+	sensor.lostAt = time.Time{}
+	sensor.backoff = 0
+}
+
+// schedule runs fn after d unless it is stopped or replaced first. mu must be
+// held.
+func (sensor *BaseSensor) schedule(d time.Duration, fn func()) {
+	sensor.stopRetry()
+	var timer *time.Timer
+	timer = time.AfterFunc(d, func() {
+		sensor.mu.Lock()
+		current := sensor.retry == timer
+		if current {
+			sensor.retry = nil
+		}
+		sensor.mu.Unlock()
+		if current {
+			fn()
+		}
+	})
+	sensor.retry = timer
+}
+
+// stopRetry cancels whatever schedule was waiting to run. mu must be held.
+func (sensor *BaseSensor) stopRetry() {
+	if sensor.retry != nil {
+		sensor.retry.Stop()
+		sensor.retry = nil
+	}
+}
@@ -0,0 +1,103 @@
+package ant
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultReconnectInterval is how often a lost stick is looked for again.
+const DefaultReconnectInterval = time.Second
+
+// OnDisconnect registers fn to be called with the error that made the stick
+// go away. The driver keeps running while it waits for the stick to return.
+func (drv *BaseDriver) OnDisconnect(fn func(error)) {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+	drv.disconnectCallbacks = append(drv.disconnectCallbacks, fn)
+}
+
+// OnReconnect registers fn to be called once a lost stick is back and every
+// sensor that was attached to it has been attached again.
+func (drv *BaseDriver) OnReconnect(fn func()) {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+	drv.reconnectCallbacks = append(drv.reconnectCallbacks, fn)
+}
+
+// enableReconnect makes the driver call reopen every interval after losing
+// its transport, until it gets a working stick back or is closed.
+func (drv *BaseDriver) enableReconnect(interval time.Duration, reopen func() (Transport, error)) {
+	drv.reconnectInterval = interval
+	drv.reopen = reopen
+}
+
+// releaseSensors forgets every attached sensor, the channels they were on
+// went away with the stick, and returns them so they can be attached again.
+func (drv *BaseDriver) releaseSensors() []*BaseSensor {
+	sensors := drv.sensors()
+	drv.mu.Lock()
+	drv.channels = nil
+	drv.scanners = nil
+	drv.usedChannels = 0
+	drv.mu.Unlock()
+	for _, sensor := range sensors {
+		channel := sensor.attachedChannel()
+		sensor.release()
+		if channel != nil {
+			sensor.emit(SensorUnassigned, *channel)
+		}
+	}
+	return sensors
+}
+
+func (drv *BaseDriver) reconnect(cause error) {
+	drv.logger().Log(LevelWarn, "lost the stick, looking for it again", errorField(cause))
+	sensors := drv.releaseSensors()
+	drv.mu.Lock()
+	disconnectCallbacks := drv.disconnectCallbacks
+	reconnectCallbacks := drv.reconnectCallbacks
+	drv.mu.Unlock()
+	for _, cb := range disconnectCallbacks {
+		cb(cause)
+	}
+	done := drv.Done()
+	ticker := time.NewTicker(drv.reconnectInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		t, err := drv.reopen()
+		if err != nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultStartupTimeout)
+		err = drv.connect(ctx, t)
+		cancel()
+		if err == ErrDriverClosed {
+			return
+		}
+		if err != nil {
+			drv.logger().Log(LevelWarn, "stick came back but did not start", errorField(err))
+			continue
+		}
+		for _, sensor := range sensors {
+			sensor.mu.Lock()
+			reattach := sensor.reattach
+			sensor.mu.Unlock()
+			if reattach == nil {
+				continue
+			}
+			if err := reattach(); err != nil {
+				drv.logger().Log(LevelWarn, "could not attach sensor again", errorField(err))
+			}
+		}
+		drv.logger().Log(LevelInfo, "stick is back")
+		for _, cb := range reconnectCallbacks {
+			cb()
+		}
+		return
+	}
+}
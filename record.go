@@ -0,0 +1,231 @@
+package ant
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Captures are plain text, one frame per line:
+//
+//	<seconds since first frame> <rx|tx> <frame bytes in hex>
+//
+// rx frames came from the stick, tx frames were written to it. Lines
+// starting with # are comments.
+const (
+	captureRX = "rx"
+	captureTX = "tx"
+)
+
+// Recorder writes every frame going through a driver to a capture.
+type Recorder struct {
+	mu    sync.Mutex
+	w     io.Writer
+	start time.Time
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: w}
+}
+
+func (r *Recorder) record(direction string, frame []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if r.start.IsZero() {
+		r.start = now
+	}
+	fmt.Fprintf(r.w, "%.6f %s % X\n", now.Sub(r.start).Seconds(), direction, frame)
+}
+
+type captureFrame struct {
+	offset    time.Duration
+	direction string
+	data      []byte
+}
+
+func parseCaptureLine(line string) (*captureFrame, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("malformed capture line %q", line)
+	}
+	seconds, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return nil, err
+	}
+	if fields[1] != captureRX && fields[1] != captureTX {
+		return nil, fmt.Errorf("unknown direction %q in capture", fields[1])
+	}
+	data, err := hex.DecodeString(strings.Join(fields[2:], ""))
+	if err != nil {
+		return nil, err
+	}
+	return &captureFrame{
+		offset:    time.Duration(seconds * float64(time.Second)),
+		direction: fields[1],
+		data:      data,
+	}, nil
+}
+
+// how long replay waits for the host to write what the capture says it wrote
+const replaySyncTimeout = time.Second
+
+// replayTransport plays the rx frames of a capture back with their original
+// spacing divided by speed. tx frames are sync points: replay holds until the
+// host writes a message of the same type, so the answers to a configuration
+// sequence never run ahead of the sensors sending it.
+type replayTransport struct {
+	scanner *bufio.Scanner
+	speed   float64
+	start   time.Time
+	pending []byte
+	written chan byte
+	done    chan struct{}
+	once    sync.Once
+}
+
+func newReplayTransport(r io.Reader, speed float64) *replayTransport {
+	return &replayTransport{
+		scanner: bufio.NewScanner(r),
+		speed:   speed,
+		written: make(chan byte, 64),
+		done:    make(chan struct{}),
+	}
+}
+
+func (t *replayTransport) Read(p []byte) (int, error) {
+	if len(t.pending) == 0 {
+		frame, err := t.next()
+		if err != nil {
+			return 0, err
+		}
+		if err := t.wait(frame.offset); err != nil {
+			return 0, err
+		}
+		t.pending = frame.data
+	}
+	n := copy(p, t.pending)
+	t.pending = t.pending[n:]
+	return n, nil
+}
+
+func (t *replayTransport) next() (*captureFrame, error) {
+	for t.scanner.Scan() {
+		line := strings.TrimSpace(t.scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		frame, err := parseCaptureLine(line)
+		if err != nil {
+			return nil, err
+		}
+		if frame.direction == captureRX {
+			return frame, nil
+		}
+		if err := t.sync(frame); err != nil {
+			return nil, err
+		}
+	}
+	if err := t.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func (t *replayTransport) wait(offset time.Duration) error {
+	if t.start.IsZero() {
+		t.start = time.Now()
+	}
+	var delay time.Duration
+	if t.speed > 0 {
+		due := t.start.Add(time.Duration(float64(offset) / t.speed))
+		delay = time.Until(due)
+	}
+	if delay <= 0 {
+		select {
+		case <-t.done:
+			return io.EOF
+		default:
+			return nil
+		}
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-t.done:
+		return io.EOF
+	case <-timer.C:
+		return nil
+	}
+}
+
+// sync waits for the host to write a frame like the recorded one, then
+// moves the time base so the frames that follow keep their spacing.
+func (t *replayTransport) sync(frame *captureFrame) error {
+	if len(frame.data) <= BufferIndexMessageType {
+		return nil
+	}
+	timeout := time.NewTimer(replaySyncTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case <-t.done:
+			return io.EOF
+		case <-timeout.C:
+			return nil
+		case msgID := <-t.written:
+			if msgID != frame.data[BufferIndexMessageType] {
+				continue
+			}
+			if t.speed > 0 {
+				t.start = time.Now().Add(-time.Duration(float64(frame.offset) / t.speed))
+			}
+			return nil
+		}
+	}
+}
+
+func (t *replayTransport) Write(p []byte) (int, error) {
+	if len(p) > BufferIndexMessageType {
+		select {
+		case t.written <- p[BufferIndexMessageType]:
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+func (t *replayTransport) Close() error {
+	t.once.Do(func() { close(t.done) })
+	return nil
+}
+
+// ReplayDriver feeds a capture made with a Recorder to the attached sensors
+// and scanners. A speed of 1 keeps the original timing, 10 plays ten times
+// faster and 0 or less plays as fast as the frames can be decoded.
+type ReplayDriver struct {
+	BaseDriver
+	capture io.Reader
+	speed   float64
+}
+
+func NewReplayDriver(capture io.Reader, speed float64) *ReplayDriver {
+	return &ReplayDriver{
+		capture: capture,
+		speed:   speed,
+	}
+}
+
+func (drv *ReplayDriver) Open() error {
+	return drv.Start(newReplayTransport(drv.capture, drv.speed))
+}
+
+func (drv *ReplayDriver) OpenContext(ctx context.Context) error {
+	return drv.StartContext(ctx, newReplayTransport(drv.capture, drv.speed))
+}
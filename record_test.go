@@ -0,0 +1,94 @@
+package ant
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer safe to write from the reader goroutine.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// recordScan records a heart rate scanner receiving rate from a simulated
+// device.
+func recordScan(t *testing.T, rate byte) string {
+	t.Helper()
+	var capture syncBuffer
+	drv := NewSimulatedDriver()
+	drv.SetRecorder(NewRecorder(&capture))
+	started := make(chan struct{}, 1)
+	drv.OnStartup(func() { started <- struct{}{} })
+	if err := drv.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer drv.Close()
+	<-started
+	scanner := NewHeartRateScanner(drv)
+	scanning := make(chan struct{}, 1)
+	scanner.SetOnAttachCallback(func() { scanning <- struct{}{} })
+	got := make(chan byte, 1)
+	scanner.ListenForData(func(s *HeartRateScannerState) { got <- s.ComputedHeartRate })
+	if err := scanner.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	<-scanning
+	drv.Stick.Emit(VirtualDevice{DeviceID: 5, DeviceType: 120, TransmissionType: 1}, heartRatePage(rate))
+	<-got
+	return capture.String()
+}
+
+func TestReplayWaitsForHostWrites(t *testing.T) {
+	capture := recordScan(t, 66)
+	if !strings.Contains(capture, " tx ") {
+		t.Fatalf("capture has no tx frames:\n%s", capture)
+	}
+
+	// played back as fast as possible, to a program that takes its time to
+	// start scanning: the data page must not be played before the scan
+	// channel it was recorded on is open again.
+	drv := NewReplayDriver(strings.NewReader(capture), 0)
+	started := make(chan struct{}, 1)
+	drv.OnStartup(func() { started <- struct{}{} })
+	if err := drv.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer drv.Close()
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("replayed stick didn't start")
+	}
+	time.Sleep(50 * time.Millisecond)
+	scanner := NewHeartRateScanner(drv)
+	scanner.SetOnAttachCallback(func() {})
+	got := make(chan byte, 1)
+	scanner.ListenForData(func(s *HeartRateScannerState) { got <- s.ComputedHeartRate })
+	if err := scanner.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case rate := <-got:
+		if rate != 66 {
+			t.Errorf("got heart rate %d, want 66", rate)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the recorded data page never reached the scanner")
+	}
+}
@@ -0,0 +1,228 @@
+package ant
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// DefaultRequestTimeout is how long a request waits for the stick to answer
+// when the driver's RequestTimeout isn't set.
+const DefaultRequestTimeout = time.Second
+
+var ErrRequestTimeout = errors.New("request timed out")
+
+// ChannelStatus is the answer to a channel status request.
+type ChannelStatus struct {
+	// State is one of the ChannelState constants
+	State         byte
+	NetworkNumber uint8
+	ChannelType   ChannelType
+}
+
+// ChannelID identifies the device a channel is paired with.
+type ChannelID struct {
+	// DeviceNumber is the 20 bit device number, its upper 4 bits are the
+	// extended nibble of the transmission type
+	DeviceNumber uint32
+	// DeviceType is the device type without the pairing bit
+	DeviceType uint8
+	// Pairing is the pairing bit of the device type
+	Pairing bool
+	// TransmissionType is as sent, extended nibble included
+	TransmissionType uint8
+}
+
+// decodeChannelID reads the 4 bytes of a channel id: device number, device
+// type and transmission type.
+func decodeChannelID(b []byte) ChannelID {
+	tt := b[3]
+	return ChannelID{
+		DeviceNumber:     uint32(binary.LittleEndian.Uint16(b[0:2])) | uint32(tt&0xF0)<<12,
+		DeviceType:       b[2] & 0x7F,
+		Pairing:          b[2]&0x80 != 0,
+		TransmissionType: tt,
+	}
+}
+
+// complete tells if every part of the id is known, a channel paired with
+// wildcards only learns the rest from the device.
+func (id ChannelID) complete() bool {
+	return id.DeviceNumber != 0 && id.DeviceType != 0 && id.TransmissionType != 0
+}
+
+type pendingRequest struct {
+	channel byte
+	msgID   byte
+	// command waits for the response to msgID, not for msgID itself
+	command  bool
+	response chan []byte
+}
+
+// matches tells if data answers the request, either with the requested
+// message or with a response to the message sent.
+func (req *pendingRequest) matches(data []byte) bool {
+	messageID := data[BufferIndexMessageType]
+	if messageID == MessageChannelEvent {
+		sent := byte(MessageChannelRequest)
+		if req.command {
+			sent = req.msgID
+		}
+		return len(data) > BufferIndexMessageData+1 &&
+			data[BufferIndexChannelNumber] == req.channel &&
+			data[BufferIndexMessageData] == sent
+	}
+	if req.command || messageID != req.msgID {
+		return false
+	}
+	switch messageID {
+	case MessageChannelStatus, MessageChannelID:
+		return len(data) > BufferIndexChannelNumber &&
+			data[BufferIndexChannelNumber] == req.channel
+	}
+	return true
+}
+
+// answer hands data to the oldest request waiting for it. The frame is still
+// dispatched as usual afterwards, sensors may be waiting for it too.
+func (drv *BaseDriver) answer(data []byte) {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+	for idx, req := range drv.requests {
+		if req.matches(data) {
+			req.response <- data
+			drv.requests = append(drv.requests[:idx], drv.requests[idx+1:]...)
+			return
+		}
+	}
+}
+
+// request asks the stick for msgID about channel and waits for the answer.
+// Don't call it from a data or status callback, the answer would never get
+// read.
+func (drv *BaseDriver) request(channel, msgID byte) ([]byte, error) {
+	req := &pendingRequest{
+		channel:  channel,
+		msgID:    msgID,
+		response: make(chan []byte, 1),
+	}
+	data, err := drv.await(req, requestMessage(uint32(channel), msgID))
+	if err != nil {
+		return nil, err
+	}
+	if data[BufferIndexMessageType] == MessageChannelEvent {
+		return nil, &ChannelError{
+			Channel:   channel,
+			MessageID: MessageChannelRequest,
+			Code:      ResponseCode(data[BufferIndexMessageData+1]),
+		}
+	}
+	return data[BufferIndexMessageType+1 : len(data)-1], nil
+}
+
+// command writes msg and waits for the stick to accept it. Like request,
+// don't call it from a data or status callback.
+func (drv *BaseDriver) command(msg []byte) error {
+	channel := msg[BufferIndexChannelNumber]
+	req := &pendingRequest{
+		channel:  channel,
+		msgID:    msg[BufferIndexMessageType],
+		command:  true,
+		response: make(chan []byte, 1),
+	}
+	data, err := drv.await(req, msg)
+	if err != nil {
+		return err
+	}
+	if code := data[BufferIndexMessageData+1]; code != ResponseNoError {
+		return &ChannelError{Channel: channel, MessageID: req.msgID, Code: ResponseCode(code)}
+	}
+	return nil
+}
+
+// await writes msg and waits for the frame answering req.
+func (drv *BaseDriver) await(req *pendingRequest, msg []byte) ([]byte, error) {
+	drv.mu.Lock()
+	drv.requests = append(drv.requests, req)
+	done := drv.done
+	timeout := drv.RequestTimeout
+	drv.mu.Unlock()
+	defer drv.dropRequest(req)
+	if timeout <= 0 {
+		timeout = DefaultRequestTimeout
+	}
+
+	if err := drv.write(msg); err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case data := <-req.response:
+		return data, nil
+	case <-timer.C:
+		return nil, ErrRequestTimeout
+	case <-done:
+		return nil, ErrDriverClosed
+	}
+}
+
+func (drv *BaseDriver) dropRequest(req *pendingRequest) {
+	drv.mu.Lock()
+	defer drv.mu.Unlock()
+	for idx, r := range drv.requests {
+		if r == req {
+			drv.requests = append(drv.requests[:idx], drv.requests[idx+1:]...)
+			return
+		}
+	}
+}
+
+func (drv *BaseDriver) RequestChannelStatus(channel uint8) (ChannelStatus, error) {
+	payload, err := drv.request(channel, MessageChannelStatus)
+	if err != nil {
+		return ChannelStatus{}, err
+	}
+	if len(payload) < 2 {
+		return ChannelStatus{}, errors.New("short channel status response")
+	}
+	return ChannelStatus{
+		State:         payload[1] & 0x03,
+		NetworkNumber: (payload[1] >> 2) & 0x03,
+		ChannelType:   ChannelType(payload[1] & 0xF0),
+	}, nil
+}
+
+func (drv *BaseDriver) RequestChannelID(channel uint8) (ChannelID, error) {
+	payload, err := drv.request(channel, MessageChannelID)
+	if err != nil {
+		return ChannelID{}, err
+	}
+	if len(payload) < 5 {
+		return ChannelID{}, errors.New("short channel id response")
+	}
+	return decodeChannelID(payload[1:5]), nil
+}
+
+// RequestCapabilities asks the stick for its capabilities again and returns
+// the updated StickInfo.
+func (drv *BaseDriver) RequestCapabilities() (StickInfo, error) {
+	if _, err := drv.request(0, MessageCapabilities); err != nil {
+		return StickInfo{}, err
+	}
+	return drv.StickInfo(), nil
+}
+
+func (drv *BaseDriver) RequestVersion() (string, error) {
+	if _, err := drv.request(0, MessageVersion); err != nil {
+		return "", err
+	}
+	return drv.StickInfo().Version, nil
+}
+
+func (drv *BaseDriver) RequestSerialNumber() (uint32, error) {
+	if _, err := drv.request(0, MessageSerialNumber); err != nil {
+		return 0, err
+	}
+	return drv.StickInfo().SerialNumber, nil
+}
@@ -0,0 +1,102 @@
+package ant
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// deafTransport never lets the requests for one message through.
+type deafTransport struct {
+	Transport
+	ignore byte
+}
+
+func (t *deafTransport) Write(p []byte) (int, error) {
+	if len(p) > BufferIndexMessageData && p[BufferIndexMessageType] == MessageChannelRequest &&
+		p[BufferIndexMessageData] == t.ignore {
+		return len(p), nil
+	}
+	return t.Transport.Write(p)
+}
+
+func TestSimulatedRequests(t *testing.T) {
+	drv := openSimulatedDriver(t)
+	if serial, err := drv.RequestSerialNumber(); err != nil || serial != 0x12345678 {
+		t.Errorf("serial number: got %#x, %v", serial, err)
+	}
+	if version, err := drv.RequestVersion(); err != nil || version != "SIM1.00" {
+		t.Errorf("version: got %q, %v", version, err)
+	}
+	if info, err := drv.RequestCapabilities(); err != nil || info.MaxChannels != 8 {
+		t.Errorf("capabilities: got %+v, %v", info, err)
+	}
+	if status, err := drv.RequestChannelStatus(2); err != nil || status.State != ChannelStateUnassigned {
+		t.Errorf("channel status: got %+v, %v", status, err)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	drv := NewBaseDriver()
+	drv.RequestTimeout = 50 * time.Millisecond
+	stick := NewSimulatedStick()
+	if err := drv.StartContext(context.Background(),
+		&deafTransport{Transport: stick.reopen(), ignore: MessageChannelStatus}); err != nil {
+		t.Fatal(err)
+	}
+	defer drv.Close()
+	start := time.Now()
+	if _, err := drv.RequestChannelStatus(0); !errors.Is(err, ErrRequestTimeout) {
+		t.Fatalf("got %v, want ErrRequestTimeout", err)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("gave up after %v", waited)
+	}
+	// the driver still answers other requests
+	if _, err := drv.RequestSerialNumber(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDecodeChannelID(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+		want ChannelID
+	}{
+		{"16 bit", []byte{0x39, 0x30, 120, 0x01}, ChannelID{DeviceNumber: 12345, DeviceType: 120, TransmissionType: 0x01}},
+		{"extended nibble", []byte{0x34, 0x12, 120, 0xA1}, ChannelID{DeviceNumber: 0xA1234, DeviceType: 120, TransmissionType: 0xA1}},
+		{"pairing bit", []byte{0x01, 0x00, 0x80 | 11, 0x05}, ChannelID{DeviceNumber: 1, DeviceType: 11, Pairing: true, TransmissionType: 0x05}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decodeChannelID(tt.b); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetDeviceRoundTrip(t *testing.T) {
+	for _, deviceID := range []uint32{0, 1, 0xFFFF, 0x10000, 0xA1234, MaxDeviceID} {
+		msg := setDevice(3, deviceID, 120, 0x01)
+		got := decodeChannelID(msg[BufferIndexMessageData:])
+		if got.DeviceNumber != deviceID {
+			t.Errorf("device %#x came back as %#x", deviceID, got.DeviceNumber)
+		}
+		if got.TransmissionType&0x0F != 0x01 || got.DeviceType != 120 {
+			t.Errorf("device %#x: got %+v", deviceID, got)
+		}
+	}
+}
+
+func TestSetDeviceWildcardTransmissionType(t *testing.T) {
+	// the nibble would turn the wildcard into a transmission type no device
+	// sends
+	msg := setDevice(3, 0xA1234, 120, 0)
+	got := decodeChannelID(msg[BufferIndexMessageData:])
+	if got.DeviceNumber != 0x1234 || got.TransmissionType != 0 {
+		t.Errorf("got %+v, want device 0x1234 with a wildcard transmission type", got)
+	}
+}
@@ -0,0 +1,49 @@
+package ant
+
+import (
+	"context"
+)
+
+// DefaultSerialBaudRate is the rate nRF24AP2 modules and the legacy ANTUSB
+// stick fall back to when nothing else has been configured.
+const DefaultSerialBaudRate = 57600
+
+type SerialConfig struct {
+	BaudRate int
+	// RTSCTS enables hardware flow control, required by modules wired
+	// with the RTS line connected.
+	RTSCTS bool
+}
+
+// SerialDriver talks to an ANT chip behind a UART or a usb to serial bridge
+// such as the CP210x in the old ANTUSB stick.
+type SerialDriver struct {
+	BaseDriver
+	path   string
+	config SerialConfig
+}
+
+func NewSerialDriver(path string, config SerialConfig) *SerialDriver {
+	if config.BaudRate == 0 {
+		config.BaudRate = DefaultSerialBaudRate
+	}
+	return &SerialDriver{
+		path:   path,
+		config: config,
+	}
+}
+
+// Open is OpenContext bounded by DefaultStartupTimeout.
+func (drv *SerialDriver) Open() error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultStartupTimeout)
+	defer cancel()
+	return drv.OpenContext(ctx)
+}
+
+func (drv *SerialDriver) OpenContext(ctx context.Context) error {
+	t, err := openSerialTransport(drv.path, drv.config)
+	if err != nil {
+		return err
+	}
+	return drv.StartContext(ctx, t)
+}
@@ -0,0 +1,80 @@
+//go:build linux
+// +build linux
+
+package ant
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var baudRates = map[int]uint32{
+	4800:   syscall.B4800,
+	9600:   syscall.B9600,
+	19200:  syscall.B19200,
+	38400:  syscall.B38400,
+	57600:  syscall.B57600,
+	115200: syscall.B115200,
+	230400: syscall.B230400,
+}
+
+func openSerialTransport(path string, config SerialConfig) (Transport, error) {
+	speed, ok := baudRates[config.BaudRate]
+	if !ok {
+		return nil, fmt.Errorf("unsupported baud rate %d", config.BaudRate)
+	}
+	// opening non blocking lets the runtime poller wake a pending Read on Close
+	f, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := setTermios(f, speed, config.RTSCTS); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// setTermios puts the tty in raw 8N1 mode at the given speed. The speed
+// only goes into the CBAUD bits of Cflag, not every architecture's Termios
+// has the Ispeed and Ospeed fields.
+func setTermios(f *os.File, speed uint32, rtscts bool) error {
+	var t syscall.Termios
+	if err := ioctl(f, syscall.TCGETS, &t); err != nil {
+		return err
+	}
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON | syscall.IXOFF
+	t.Oflag &^= syscall.OPOST
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB | syscall.CSTOPB | termiosCBAUD | termiosCRTSCTS
+	t.Cflag |= syscall.CS8 | syscall.CREAD | syscall.CLOCAL | speed
+	if rtscts {
+		t.Cflag |= termiosCRTSCTS
+	}
+	t.Cc[syscall.VMIN] = 1
+	t.Cc[syscall.VTIME] = 0
+	return ioctl(f, syscall.TCSETS, &t)
+}
+
+// ioctl goes through SyscallConn since f.Fd() would switch the file back to
+// blocking mode.
+func ioctl(f *os.File, req uintptr, t *syscall.Termios) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	err = conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(unsafe.Pointer(t)))
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
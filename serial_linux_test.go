@@ -0,0 +1,84 @@
+package ant
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// openPTY opens a pseudo terminal and returns its master side and the path
+// of its slave.
+func openPTY(t *testing.T) (*os.File, string) {
+	t.Helper()
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("no pseudo terminals: %v", err)
+	}
+	var unlock int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCSPTLCK,
+		uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		master.Close()
+		t.Fatal(errno)
+	}
+	var number uint32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCGPTN,
+		uintptr(unsafe.Pointer(&number))); errno != 0 {
+		master.Close()
+		t.Fatal(errno)
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", number)
+}
+
+func TestSerialDriverOverPTY(t *testing.T) {
+	master, path := openPTY(t)
+	defer master.Close()
+	// a simulated stick answers on the master side of the terminal
+	stick := NewSimulatedStick()
+	conn := stick.reopen()
+	defer conn.Close()
+	go io.Copy(conn, master)
+	go io.Copy(master, conn)
+
+	drv := NewSerialDriver(path, SerialConfig{BaudRate: 115200})
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := drv.OpenContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer drv.Close()
+	if serial, err := drv.RequestSerialNumber(); err != nil || serial != stick.SerialNumber {
+		t.Fatalf("serial number: got %#x, %v", serial, err)
+	}
+
+	hr := NewHeartRateSensor(drv)
+	states := make(chan byte, 1)
+	hr.ListenForData(func(s *HeartRateSensorState) { states <- s.ComputedHeartRate })
+	if err := hr.Attach(12); err != nil {
+		t.Fatal(err)
+	}
+	// 0x0A and 0x0D go through the terminal as they are
+	stick.Emit(VirtualDevice{DeviceID: 12, DeviceType: HeartRateSensorDeviceType, TransmissionType: 1},
+		[]byte{0, 0x0A, 0x0D, 0, 0x0D, 0x0A, 1, 0x0D})
+	select {
+	case rate := <-states:
+		if rate != 0x0D {
+			t.Errorf("got heart rate %d, want 13", rate)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no data through the terminal")
+	}
+}
+
+func TestSerialDriverBaudRate(t *testing.T) {
+	_, path := openPTY(t)
+	drv := NewSerialDriver(path, SerialConfig{BaudRate: 12345})
+	if err := drv.OpenContext(context.Background()); err == nil {
+		drv.Close()
+		t.Fatal("opened with an unsupported baud rate")
+	}
+}
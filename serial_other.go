@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package ant
+
+import (
+	"errors"
+)
+
+func openSerialTransport(path string, config SerialConfig) (Transport, error) {
+	return nil, errors.New("serial transport is only supported on linux")
+}
@@ -0,0 +1,10 @@
+//go:build linux && !ppc64 && !ppc64le
+// +build linux,!ppc64,!ppc64le
+
+package ant
+
+// not exported by the syscall package
+const (
+	termiosCBAUD   = 0x100F
+	termiosCRTSCTS = 0x80000000
+)
@@ -0,0 +1,12 @@
+//go:build linux && (ppc64 || ppc64le)
+// +build linux
+// +build ppc64 ppc64le
+
+package ant
+
+// not exported by the syscall package, powerpc keeps the baud rate in the
+// low byte of Cflag
+const (
+	termiosCBAUD   = 0xFF
+	termiosCRTSCTS = 0x80000000
+)
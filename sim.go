@@ -0,0 +1,428 @@
+package ant
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+)
+
+// VirtualDevice is an ANT+ device living on a SimulatedStick. A DeviceID
+// above 0xFFFF sends its upper nibble in the transmission type, the way a
+// real device does.
+type VirtualDevice struct {
+	DeviceID         uint32
+	DeviceType       uint8
+	TransmissionType uint8
+	RSSI             int8
+	Threshold        int8
+}
+
+type simChannel struct {
+	assigned         bool
+	open             bool
+	deviceID         uint16
+	deviceType       uint8
+	transmissionType uint8
+	// device the channel paired with when it was opened with wildcards
+	paired *VirtualDevice
+}
+
+// SimulatedStick is an in-memory Transport answering like a real ANT stick.
+// Configuration messages are acknowledged, requests are answered and
+// broadcasts can be scripted with Emit, which makes it possible to run
+// sensors and scanners without any hardware.
+type SimulatedStick struct {
+	MaxChannels  int
+	MaxNetworks  int
+	SerialNumber uint32
+	Version      string
+	// NoScan leaves continuous scanning mode out of the capabilities, the
+	// stick refuses to scan like one without it would
+	NoScan bool
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  [][]byte
+	closed bool
+	// bumped by reopen, see simConn
+	gen       int
+	channels  map[uint8]*simChannel
+	scanning  bool
+	libConfig byte
+	started   time.Time
+}
+
+func NewSimulatedStick() *SimulatedStick {
+	s := &SimulatedStick{
+		MaxChannels:  8,
+		MaxNetworks:  8,
+		SerialNumber: 0x12345678,
+		Version:      "SIM1.00",
+		channels:     make(map[uint8]*simChannel),
+		started:      time.Now(),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// generation used when going through the stick itself rather than a
+// simConn, it is always current
+const anyConn = -1
+
+// stale tells if connection gen was made before the stick was last
+// reopened. mu must be held.
+func (s *SimulatedStick) stale(gen int) bool {
+	return gen != anyConn && gen != s.gen
+}
+
+func (s *SimulatedStick) Read(p []byte) (int, error) {
+	return s.read(p, anyConn)
+}
+
+func (s *SimulatedStick) read(p []byte, gen int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.queue) == 0 && !s.closed && !s.stale(gen) {
+		s.cond.Wait()
+	}
+	if s.closed || s.stale(gen) {
+		return 0, io.EOF
+	}
+	n := copy(p, s.queue[0])
+	if n < len(s.queue[0]) {
+		s.queue[0] = s.queue[0][n:]
+	} else {
+		s.queue = s.queue[1:]
+	}
+	return n, nil
+}
+
+func (s *SimulatedStick) Write(p []byte) (int, error) {
+	return s.write(p, anyConn)
+}
+
+func (s *SimulatedStick) write(p []byte, gen int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || s.stale(gen) {
+		return 0, io.ErrClosedPipe
+	}
+	for begin := 0; begin+3 < len(p); {
+		end := begin + int(p[begin+1]) + 4
+		if p[begin] != MessageTXSync || end > len(p) {
+			break
+		}
+		s.handle(p[begin+2], p[begin+3:end-1])
+		begin = end
+	}
+	return len(p), nil
+}
+
+func (s *SimulatedStick) Close() error {
+	return s.close(anyConn)
+}
+
+func (s *SimulatedStick) close(gen int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stale(gen) {
+		return nil
+	}
+	s.closed = true
+	s.cond.Broadcast()
+	return nil
+}
+
+// simConn is one connection to a SimulatedStick. It stops working once the
+// stick is reopened, so a reader left over from before can't steal frames
+// from the next connection.
+type simConn struct {
+	stick *SimulatedStick
+	gen   int
+}
+
+func (c *simConn) Read(p []byte) (int, error)  { return c.stick.read(p, c.gen) }
+func (c *simConn) Write(p []byte) (int, error) { return c.stick.write(p, c.gen) }
+func (c *simConn) Close() error                { return c.stick.close(c.gen) }
+
+// reopen brings the stick back as if it had just been plugged in, and
+// returns a connection to it. Earlier connections go stale.
+func (s *SimulatedStick) reopen() Transport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = false
+	s.gen++
+	s.cond.Broadcast()
+	s.queue = nil
+	s.channels = make(map[uint8]*simChannel)
+	s.scanning = false
+	return &simConn{stick: s, gen: s.gen}
+}
+
+// Emit sends one 8 byte data page from dev to every open channel that would
+// receive it, and to the scan channel if one is open.
+func (s *SimulatedStick) Emit(dev VirtualDevice, page []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.scanning {
+		s.push(s.dataFrame(MessageChannelBroadcastData, 0, dev, page))
+		return
+	}
+	for number, ch := range s.channels {
+		if !ch.open || !ch.matches(dev) {
+			continue
+		}
+		if ch.paired == nil {
+			d := dev
+			ch.paired = &d
+		}
+		s.push(s.dataFrame(MessageChannelBroadcastData, number, dev, page))
+	}
+}
+
+// SendEvent injects a channel event, such as EventRXFailGoToSearch, on channel.
+func (s *SimulatedStick) SendEvent(channel uint8, code byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.push(buildMessage([]byte{channel, MessageRF, code}, MessageChannelEvent))
+}
+
+// transmissionType is what dev sends as its transmission type.
+func (dev VirtualDevice) transmissionType() uint8 {
+	if dev.DeviceID <= 0xFFFF {
+		return dev.TransmissionType
+	}
+	return dev.TransmissionType&0x0F | byte(dev.DeviceID>>16&0x0F)<<4
+}
+
+func (ch *simChannel) matches(dev VirtualDevice) bool {
+	if ch.paired != nil {
+		return ch.paired.DeviceID == dev.DeviceID && ch.paired.DeviceType == dev.DeviceType
+	}
+	return (ch.deviceID == 0 || ch.deviceID == uint16(dev.DeviceID)) &&
+		(ch.deviceType == 0 || ch.deviceType == dev.DeviceType) &&
+		(ch.transmissionType == 0 || ch.transmissionType == dev.transmissionType())
+}
+
+func (s *SimulatedStick) dataFrame(msgID, channel byte, dev VirtualDevice, page []byte) []byte {
+	payload := []byte{channel}
+	payload = append(payload, page...)
+	if s.libConfig != 0 {
+		payload = append(payload, s.libConfig)
+		if s.libConfig&0x80 != 0 {
+			payload = append(payload, byte(dev.DeviceID), byte(dev.DeviceID>>8),
+				dev.DeviceType, dev.transmissionType())
+		}
+		if s.libConfig&0x40 != 0 {
+			payload = append(payload, 0x20, byte(dev.RSSI), byte(dev.Threshold))
+		}
+		if s.libConfig&0x20 != 0 {
+			ticks := uint16(time.Since(s.started) * 32768 / time.Second)
+			payload = append(payload, byte(ticks), byte(ticks>>8))
+		}
+	}
+	return buildMessage(payload, msgID)
+}
+
+func (s *SimulatedStick) push(frame []byte) {
+	s.queue = append(s.queue, frame)
+	s.cond.Broadcast()
+}
+
+func (s *SimulatedStick) respond(channel, msgID, code byte) {
+	s.push(buildMessage([]byte{channel, msgID, code}, MessageChannelEvent))
+}
+
+func (s *SimulatedStick) channel(number byte) *simChannel {
+	ch, ok := s.channels[number]
+	if !ok {
+		ch = &simChannel{}
+		s.channels[number] = ch
+	}
+	return ch
+}
+
+func (s *SimulatedStick) handle(msgID byte, payload []byte) {
+	if len(payload) == 0 {
+		return
+	}
+	number := payload[0]
+	switch msgID {
+	case MessageSystemReset:
+		s.channels = make(map[uint8]*simChannel)
+		s.scanning = false
+		s.libConfig = 0
+		s.push(buildMessage([]byte{0x20}, MessageStartup))
+	case MessageChannelRequest:
+		if len(payload) < 2 {
+			return
+		}
+		s.request(number, payload[1])
+	case MessageNetworkKey:
+		if int(number) >= s.MaxNetworks {
+			s.respond(number, msgID, InvalidNetworkNumber)
+			return
+		}
+		s.respond(number, msgID, ResponseNoError)
+	case MessageChannelAssign:
+		if int(number) >= s.MaxChannels {
+			s.respond(number, msgID, InvalidParameterProvided)
+			return
+		}
+		if len(payload) > 2 && int(payload[2]) >= s.MaxNetworks {
+			s.respond(number, msgID, InvalidNetworkNumber)
+			return
+		}
+		ch := s.channel(number)
+		if ch.assigned {
+			s.respond(number, msgID, ChannelInWrongState)
+			return
+		}
+		ch.assigned = true
+		s.respond(number, msgID, ResponseNoError)
+	case MessageChannelUnassign:
+		ch := s.channel(number)
+		if !ch.assigned || ch.open {
+			s.respond(number, msgID, ChannelInWrongState)
+			return
+		}
+		delete(s.channels, number)
+		s.respond(number, msgID, ResponseNoError)
+	case MessageChannelID:
+		if len(payload) < 5 {
+			s.respond(number, msgID, InvalidMessage)
+			return
+		}
+		ch := s.channel(number)
+		ch.deviceID = binary.LittleEndian.Uint16(payload[1:3])
+		ch.deviceType = payload[3]
+		ch.transmissionType = payload[4]
+		s.respond(number, msgID, ResponseNoError)
+	case MessageChannelOpen:
+		ch := s.channel(number)
+		if !ch.assigned || ch.open {
+			s.respond(number, msgID, ChannelInWrongState)
+			return
+		}
+		ch.open = true
+		s.respond(number, msgID, ResponseNoError)
+	case MessageChannelOpenRXScan:
+		if s.NoScan {
+			s.respond(0, msgID, InvalidMessage)
+			return
+		}
+		ch := s.channel(0)
+		if !ch.assigned || ch.open {
+			s.respond(0, msgID, ChannelInWrongState)
+			return
+		}
+		ch.open = true
+		s.scanning = true
+		s.respond(0, msgID, ResponseNoError)
+	case MessageChannelClose:
+		ch := s.channel(number)
+		if !ch.open {
+			s.respond(number, msgID, ChannelInWrongState)
+			return
+		}
+		ch.open = false
+		ch.paired = nil
+		if number == 0 {
+			s.scanning = false
+		}
+		s.respond(number, msgID, ResponseNoError)
+		s.push(buildMessage([]byte{number, MessageRF, EventChannelClosed}, MessageChannelEvent))
+	case MessageLibConfig:
+		if len(payload) > 1 {
+			s.libConfig = payload[1]
+		}
+		s.respond(number, msgID, ResponseNoError)
+	case MessageChannelAcknowledgedData:
+		if ch := s.channel(number); !ch.open {
+			s.respond(number, msgID, ChannelNotOpened)
+			return
+		}
+		s.push(buildMessage([]byte{number, MessageRF, EventTransferTXCompleted}, MessageChannelEvent))
+	case MessageChannelBroadcastData:
+		if ch := s.channel(number); !ch.open {
+			s.respond(number, msgID, ChannelNotOpened)
+		}
+	default:
+		// network key, period, frequency, timeouts, rx ext...
+		s.respond(number, msgID, ResponseNoError)
+	}
+}
+
+func (s *SimulatedStick) request(number, msgID byte) {
+	switch msgID {
+	case MessageCapabilities:
+		options2 := byte(0x36)
+		if s.NoScan {
+			options2 &^= CapabilitiesScanModeEnabled
+		}
+		s.push(buildMessage([]byte{byte(s.MaxChannels), byte(s.MaxNetworks),
+			0x00, 0xBA, options2, 0x00, 0xDF, 0x00}, MessageCapabilities))
+	case MessageVersion:
+		version := make([]byte, 11)
+		copy(version, s.Version)
+		s.push(buildMessage(version, MessageVersion))
+	case MessageSerialNumber:
+		serial := make([]byte, 4)
+		binary.LittleEndian.PutUint32(serial, s.SerialNumber)
+		s.push(buildMessage(serial, MessageSerialNumber))
+	case MessageChannelID:
+		ch := s.channel(number)
+		id, deviceType, transmissionType := ch.deviceID, ch.deviceType, ch.transmissionType
+		if ch.paired != nil {
+			id, deviceType, transmissionType = uint16(ch.paired.DeviceID),
+				ch.paired.DeviceType, ch.paired.transmissionType()
+		}
+		s.push(buildMessage([]byte{number, byte(id), byte(id >> 8), deviceType,
+			transmissionType}, MessageChannelID))
+	case MessageChannelStatus:
+		ch := s.channel(number)
+		var state byte = ChannelStateUnassigned
+		switch {
+		case ch.open && ch.paired != nil:
+			state = ChannelStateTracking
+		case ch.open:
+			state = ChannelStateSearching
+		case ch.assigned:
+			state = ChannelStateAssigned
+		}
+		s.push(buildMessage([]byte{number, state}, MessageChannelStatus))
+	default:
+		s.respond(number, MessageChannelRequest, InvalidMessage)
+	}
+}
+
+// SimulatedDriver runs the regular protocol engine against a SimulatedStick.
+type SimulatedDriver struct {
+	BaseDriver
+	Stick *SimulatedStick
+}
+
+func NewSimulatedDriver() *SimulatedDriver {
+	return &SimulatedDriver{
+		Stick: NewSimulatedStick(),
+	}
+}
+
+func (drv *SimulatedDriver) Open() error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultStartupTimeout)
+	defer cancel()
+	return drv.OpenContext(ctx)
+}
+
+func (drv *SimulatedDriver) OpenContext(ctx context.Context) error {
+	// reopening the stick would cut off the driver using it
+	drv.mu.Lock()
+	running := drv.running
+	drv.mu.Unlock()
+	if running {
+		return ErrDriverOpen
+	}
+	return drv.StartContext(ctx, drv.Stick.reopen())
+}
@@ -0,0 +1,493 @@
+package ant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// openSimulatedDriver opens a SimulatedDriver and waits for its stick to
+// start.
+func openSimulatedDriver(t *testing.T) *SimulatedDriver {
+	t.Helper()
+	drv := NewSimulatedDriver()
+	started := make(chan struct{}, 1)
+	drv.OnStartup(func() {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+	})
+	if err := drv.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(drv.Close)
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("stick didn't start")
+	}
+	return drv
+}
+
+// heartRatePage is a heart rate data page with rate as the computed heart
+// rate.
+func heartRatePage(rate byte) []byte {
+	return []byte{0, 0, 0, 0, 0, 0, 1, rate}
+}
+
+// eventRecorder collects the events of a sensor.
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []SensorEventType
+}
+
+func (r *eventRecorder) record(e SensorEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e.Type)
+}
+
+// waitFor waits until the last event recorded is want.
+func (r *eventRecorder) waitFor(t *testing.T, want SensorEventType) []SensorEventType {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		r.mu.Lock()
+		events := append([]SensorEventType{}, r.events...)
+		r.mu.Unlock()
+		if len(events) > 0 && events[len(events)-1] == want {
+			return events
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got events %v, still waiting for %v", events, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSimulatedScan(t *testing.T) {
+	tests := []struct {
+		name       string
+		deviceType uint8
+		// scanner returns a scanner sending what it decoded to got
+		scanner func(drv Driver, got chan<- string) *AntPlusScanner
+		pages   [][]byte
+		want    string
+	}{
+		{
+			name:       "heart rate",
+			deviceType: 120,
+			scanner: func(drv Driver, got chan<- string) *AntPlusScanner {
+				scanner := NewHeartRateScanner(drv)
+				scanner.ListenForData(func(s *HeartRateScannerState) {
+					got <- fmt.Sprintf("device %d: %d bpm", s.DeviceID, s.ComputedHeartRate)
+				})
+				return scanner.AntPlusScanner
+			},
+			pages: [][]byte{heartRatePage(72)},
+			want:  "device 4660: 72 bpm",
+		},
+		{
+			name:       "speed",
+			deviceType: 0x7B,
+			scanner: func(drv Driver, got chan<- string) *AntPlusScanner {
+				scanner := NewSpeedScanner(drv)
+				scanner.ListenForData(func(s *SpeedScannerState) {
+					got <- fmt.Sprintf("device %d: %d revolutions, %.3f m/s", s.DeviceID,
+						s.CumulativeSpeedRevolutionCount, s.CalculatedSpeed)
+				})
+				return scanner.AntPlusScanner
+			},
+			// two wheel revolutions in one second
+			pages: [][]byte{
+				{0, 0, 0, 0, 0x00, 0x04, 10, 0},
+				{0, 0, 0, 0, 0x00, 0x08, 12, 0},
+			},
+			want: "device 4660: 12 revolutions, 4.398 m/s",
+		},
+		{
+			name:       "stride speed distance",
+			deviceType: 124,
+			scanner: func(drv Driver, got chan<- string) *AntPlusScanner {
+				scanner := NewStrideSpeedDistanceScanner(drv)
+				scanner.ListenForData(func(s *StrideSpeedDistanceScannerState) {
+					got <- fmt.Sprintf("device %d: %d strides, %d m", s.DeviceID,
+						s.StrideCount, s.DistanceInteger)
+				})
+				return scanner.AntPlusScanner
+			},
+			// the first page only sets up the page detection
+			pages: [][]byte{
+				{0x02, 0, 0, 0, 0, 0, 0, 0},
+				{0x01, 0, 10, 25, 0x31, 0x80, 42, 0},
+			},
+			want: "device 4660: 42 strides, 25 m",
+		},
+		{
+			name:       "bike radar",
+			deviceType: 0x28,
+			scanner: func(drv Driver, got chan<- string) *AntPlusScanner {
+				scanner := NewBikeRadarScanner(drv)
+				scanner.ListenForData(func(s *BikeRadarScannerState) {
+					target := s.Targets[0]
+					if target == nil {
+						got <- fmt.Sprintf("device %d: no target", s.DeviceID)
+						return
+					}
+					got <- fmt.Sprintf("device %d: threat %d at %.2f m, %.2f m/s", s.DeviceID,
+						target.ThreatLevel, target.Range, target.Speed)
+				})
+				return scanner.AntPlusScanner
+			},
+			pages: [][]byte{
+				{0x50, 0xFF, 0xFF, 1, 0xFF, 0, 1, 0},
+				{0x30, 0x02, 0x00, 0, 0, 16, 0x05, 0},
+			},
+			want: "device 4660: threat 2 at 50.00 m, 15.20 m/s",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drv := openSimulatedDriver(t)
+			got := make(chan string, len(tt.pages)+1)
+			scanner := tt.scanner(drv, got)
+			scanning := make(chan struct{}, 1)
+			scanner.SetOnAttachCallback(func() {
+				select {
+				case scanning <- struct{}{}:
+				default:
+				}
+			})
+			if err := scanner.Scan(); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case <-scanning:
+			case <-time.After(time.Second):
+				t.Fatal("scan channel never opened")
+			}
+			dev := VirtualDevice{DeviceID: 0x1234, DeviceType: tt.deviceType, TransmissionType: 1}
+			// other device types are ignored
+			drv.Stick.Emit(VirtualDevice{DeviceID: 1, DeviceType: 0x7F, TransmissionType: 1},
+				heartRatePage(90))
+			for _, page := range tt.pages {
+				drv.Stick.Emit(dev, page)
+			}
+			var last string
+			for range tt.pages {
+				select {
+				case last = <-got:
+				case <-time.After(time.Second):
+					t.Fatal("no data from the device")
+				}
+			}
+			if last != tt.want {
+				t.Errorf("got %q, want %q", last, tt.want)
+			}
+		})
+	}
+}
+
+func TestSimulatedNoFreeChannel(t *testing.T) {
+	drv := NewSimulatedDriver()
+	drv.Stick.MaxChannels = 2
+	if err := drv.OpenContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer drv.Close()
+	for i := 0; i < 2; i++ {
+		if err := NewHeartRateSensor(drv).Attach(0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := NewHeartRateSensor(drv).Attach(0)
+	var noFree *NoFreeChannelError
+	if !errors.As(err, &noFree) || noFree.MaxChannels != 2 {
+		t.Fatalf("got %v, want NoFreeChannelError for 2 channels", err)
+	}
+	if !errors.Is(err, ErrCannotAttach) {
+		t.Error("NoFreeChannelError doesn't match ErrCannotAttach")
+	}
+}
+
+func TestSimulatedAttachDetach(t *testing.T) {
+	drv := openSimulatedDriver(t)
+	hr := NewHeartRateSensor(drv)
+	states := make(chan HeartRateSensorState, 1)
+	hr.ListenForData(func(s *HeartRateSensorState) { states <- *s })
+
+	if err := hr.Attach(77); err != nil {
+		t.Fatal(err)
+	}
+	if err := hr.Attach(77); err != ErrAlreadyAttached {
+		t.Fatalf("attaching twice: got %v, want ErrAlreadyAttached", err)
+	}
+	drv.Stick.Emit(VirtualDevice{DeviceID: 77, DeviceType: HeartRateSensorDeviceType, TransmissionType: 1},
+		heartRatePage(65))
+	select {
+	case s := <-states:
+		if s.ComputedHeartRate != 65 || s.DeviceID != 77 {
+			t.Errorf("got heart rate %d from device %d", s.ComputedHeartRate, s.DeviceID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no data from the device")
+	}
+	if err := hr.Detach(); err != nil {
+		t.Fatal(err)
+	}
+	if hr.attachedChannel() != nil {
+		t.Fatal("still attached after Detach")
+	}
+	if err := hr.Attach(0); err != nil {
+		t.Fatalf("attaching again: %v", err)
+	}
+	if err := NewHeartRateScanner(drv).Scan(); !errors.Is(err, ErrCannotAttach) {
+		t.Errorf("scanning next to an attached sensor: got %v, want ErrCannotAttach", err)
+	}
+}
+
+func TestSimulatedSensors(t *testing.T) {
+	tests := []struct {
+		name       string
+		deviceType uint8
+		// attach attaches a sensor to device, sending what it decoded to got
+		attach func(drv Driver, device uint32, got chan<- string) error
+		pages  [][]byte
+		want   string
+	}{
+		{
+			name:       "heart rate",
+			deviceType: HeartRateSensorDeviceType,
+			attach: func(drv Driver, device uint32, got chan<- string) error {
+				sensor := NewHeartRateSensor(drv)
+				sensor.ListenForData(func(s *HeartRateSensorState) {
+					got <- fmt.Sprintf("device %d: %d bpm", s.DeviceID, s.ComputedHeartRate)
+				})
+				return sensor.Attach(device)
+			},
+			pages: [][]byte{heartRatePage(72)},
+			want:  "device 4660: 72 bpm",
+		},
+		{
+			name:       "speed",
+			deviceType: SpeedSensorDeviceType,
+			attach: func(drv Driver, device uint32, got chan<- string) error {
+				sensor := NewSpeedSensor(drv)
+				sensor.SetWheelCircumference(2)
+				sensor.ListenForData(func(s *SpeedSensorState) {
+					got <- fmt.Sprintf("device %d: %d revolutions, %.3f m/s", s.DeviceID,
+						s.CumulativeSpeedRevolutionCount, s.CalculatedSpeed)
+				})
+				return sensor.Attach(device)
+			},
+			// three wheel revolutions in half a second
+			pages: [][]byte{
+				{0, 0, 0, 0, 0x00, 0x04, 10, 0},
+				{0, 0, 0, 0, 0x00, 0x06, 13, 0},
+			},
+			want: "device 4660: 13 revolutions, 12.000 m/s",
+		},
+		{
+			name:       "stride speed distance",
+			deviceType: StrideSpeedDistanceSensorDeviceType,
+			attach: func(drv Driver, device uint32, got chan<- string) error {
+				sensor := NewStrideSpeedDistanceSensor(drv)
+				sensor.ListenForData(func(s *StrideSpeedDistanceSensorState) {
+					got <- fmt.Sprintf("device %d: cadence %d, %d+%d/256 m/s", s.DeviceID, s.CadenceInteger,
+						s.SpeedInteger, s.SpeedFractional)
+				})
+				return sensor.Attach(device)
+			},
+			pages: [][]byte{
+				{0x01, 0, 0, 0, 0, 0, 0, 0},
+				{0x02, 0, 0, 88, 0x53, 0x40, 0, 0},
+			},
+			want: "device 4660: cadence 88, 3+64/256 m/s",
+		},
+		{
+			name:       "bike radar",
+			deviceType: BikeRadarSensorDeviceType,
+			attach: func(drv Driver, device uint32, got chan<- string) error {
+				sensor := NewBikeRadarSensor(drv)
+				sensor.ListenForData(func(s *BikeRadarSensorState) {
+					got <- fmt.Sprintf("device %d: manufacturer %d, model %d", s.DeviceID, s.ManID, s.ModelNumber)
+				})
+				return sensor.Attach(device)
+			},
+			pages: [][]byte{
+				{0x30, 0, 0, 0, 0, 0, 0, 0},
+				{0x50, 0xFF, 0xFF, 1, 0x20, 0x01, 0x07, 0x00},
+			},
+			want: "device 4660: manufacturer 288, model 7",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drv := openSimulatedDriver(t)
+			got := make(chan string, len(tt.pages))
+			if err := tt.attach(drv, 0x1234, got); err != nil {
+				t.Fatal(err)
+			}
+			dev := VirtualDevice{DeviceID: 0x1234, DeviceType: tt.deviceType, TransmissionType: 1}
+			// the channel only takes its own device
+			drv.Stick.Emit(VirtualDevice{DeviceID: 0x4321, DeviceType: tt.deviceType, TransmissionType: 1},
+				tt.pages[0])
+			for _, page := range tt.pages {
+				drv.Stick.Emit(dev, page)
+			}
+			var last string
+			for range tt.pages {
+				select {
+				case last = <-got:
+				case <-time.After(time.Second):
+					t.Fatal("no data from the device")
+				}
+			}
+			if last != tt.want {
+				t.Errorf("got %q, want %q", last, tt.want)
+			}
+		})
+	}
+}
+
+func TestSimulatedAttach20BitDevice(t *testing.T) {
+	drv := openSimulatedDriver(t)
+	want := VirtualDevice{DeviceID: 0xA1234, DeviceType: HeartRateSensorDeviceType, TransmissionType: 1}
+	// shares the lower 16 bits, the stick pairs with it just as well
+	other := VirtualDevice{DeviceID: 0xB1234, DeviceType: HeartRateSensorDeviceType, TransmissionType: 1}
+
+	hr := NewHeartRateSensor(drv)
+	var events eventRecorder
+	hr.OnEvent(events.record)
+	states := make(chan HeartRateSensorState, 4)
+	hr.ListenForData(func(s *HeartRateSensorState) { states <- *s })
+	if err := hr.Attach(want.DeviceID); err != nil {
+		t.Fatal(err)
+	}
+	drv.Stick.Emit(other, heartRatePage(70))
+	events.waitFor(t, SensorUnassigned)
+	select {
+	case s := <-states:
+		t.Fatalf("got data from device %#x", s.DeviceID)
+	default:
+	}
+
+	if err := hr.Attach(want.DeviceID); err != nil {
+		t.Fatal(err)
+	}
+	// the first pages are held back until the channel id is in
+	deadline := time.After(time.Second)
+	for {
+		drv.Stick.Emit(want, heartRatePage(80))
+		select {
+		case s := <-states:
+			if s.DeviceID != want.DeviceID || s.ChannelID.TransmissionType != 0xA1 {
+				t.Errorf("got device %#x with transmission type %#x", s.DeviceID,
+					s.ChannelID.TransmissionType)
+			}
+			return
+		case <-deadline:
+			t.Fatal("no data from the device")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestSimulatedReacquire(t *testing.T) {
+	drv := openSimulatedDriver(t)
+	dev := VirtualDevice{DeviceID: 3, DeviceType: HeartRateSensorDeviceType, TransmissionType: 1}
+
+	t.Run("reopen", func(t *testing.T) {
+		hr := NewHeartRateSensor(drv)
+		var events eventRecorder
+		hr.OnEvent(events.record)
+		hr.SetReacquirePolicy(&ReacquirePolicy{Backoff: 10 * time.Millisecond})
+		if err := hr.Attach(0); err != nil {
+			t.Fatal(err)
+		}
+		defer hr.Detach()
+		drv.Stick.Emit(dev, heartRatePage(60))
+		events.waitFor(t, SensorTracking)
+		channel := *hr.attachedChannel()
+		drv.Stick.SendEvent(uint8(channel), EventRXFailGoToSearch)
+		got := events.waitFor(t, SensorSearching)
+		want := []SensorEventType{SensorAssigned, SensorSearching, SensorTracking, SensorDroppedToSearch,
+			SensorClosed, SensorUnassigned, SensorAssigned, SensorSearching}
+		if len(got) != len(want) {
+			t.Fatalf("got events %v, want %v", got, want)
+		}
+		for idx := range want {
+			if got[idx] != want[idx] {
+				t.Fatalf("got events %v, want %v", got, want)
+			}
+		}
+	})
+
+	t.Run("give up", func(t *testing.T) {
+		hr := NewHeartRateSensor(drv)
+		var events eventRecorder
+		hr.OnEvent(events.record)
+		hr.SetReacquirePolicy(&ReacquirePolicy{KeepSearching: true, GiveUpAfter: 50 * time.Millisecond})
+		if err := hr.Attach(0); err != nil {
+			t.Fatal(err)
+		}
+		drv.Stick.Emit(dev, heartRatePage(60))
+		events.waitFor(t, SensorTracking)
+		drv.Stick.SendEvent(uint8(*hr.attachedChannel()), EventRXFailGoToSearch)
+		events.waitFor(t, SensorLost)
+		if hr.attachedChannel() != nil {
+			t.Error("still attached after giving up")
+		}
+	})
+}
+
+func TestSimulatedSubscribeDropPolicy(t *testing.T) {
+	drv := openSimulatedDriver(t)
+	hr := NewHeartRateSensor(drv)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	oldest := hr.Subscribe(ctx, SubscribeOptions{Buffer: 2, Drop: DropOldest})
+	newest := hr.Subscribe(ctx, SubscribeOptions{Buffer: 2, Drop: DropNewest})
+	last := make(chan struct{}, 1)
+	hr.ListenForData(func(s *HeartRateSensorState) {
+		if s.ComputedHeartRate == 105 {
+			last <- struct{}{}
+		}
+	})
+	if err := hr.Attach(0); err != nil {
+		t.Fatal(err)
+	}
+	dev := VirtualDevice{DeviceID: 3, DeviceType: HeartRateSensorDeviceType, TransmissionType: 1}
+	for rate := byte(101); rate <= 105; rate++ {
+		drv.Stick.Emit(dev, heartRatePage(rate))
+	}
+	select {
+	case <-last:
+	case <-time.After(time.Second):
+		t.Fatal("not every state came in")
+	}
+
+	for _, tt := range []struct {
+		name string
+		ch   <-chan *HeartRateSensorState
+		want []byte
+	}{
+		{"drop oldest", oldest, []byte{104, 105}},
+		{"drop newest", newest, []byte{101, 102}},
+	} {
+		for _, rate := range tt.want {
+			if s := <-tt.ch; s.ComputedHeartRate != rate {
+				t.Errorf("%s: got heart rate %d, want %d", tt.name, s.ComputedHeartRate, rate)
+			}
+		}
+	}
+	cancel()
+	for range oldest {
+	}
+	for range newest {
+	}
+}
@@ -1,11 +1,14 @@
 package ant
 
 import (
+	"context"
 	"encoding/binary"
+	"sync"
 )
 
 const (
 	SpeedSensorDeviceType = 0x7B
+	SpeedSensorPeriod = 8118
 	DefaultWheelCircumference = 2.199
 )
 
@@ -14,6 +17,8 @@ const (
 // -------------------------------------------------------------
 type SpeedSensorState struct {
 	DeviceID                       uint32
+	ChannelID                      ChannelID
+	Signal
 	SpeedEventTime                 uint32
 	CumulativeSpeedRevolutionCount uint32
 	CalculatedDistance             float32
@@ -42,7 +47,7 @@ func (s *SpeedSensorState) update(data []byte) {
 			s.OperatingTime *= 2
 		case 2:
 			s.ManID = data[BufferIndexMessageData+1]
-			s.SerialNumber = uint32(s.DeviceID)
+			s.SerialNumber = s.DeviceID & 0xFFFF
 			s.SerialNumber |= uint32(binary.LittleEndian.Uint16(data[BufferIndexMessageData + 2:BufferIndexMessageData+4])) << 16
 			s.SerialNumber ^= 0x80000000
 		case 3:
@@ -113,8 +118,14 @@ func (s *SpeedSensorState) update(data []byte) {
 // -------------------------------------------------------------
 type SpeedScannerState struct {
 	*SpeedSensorState
-	RSSI uint32
-	Threshold uint32
+}
+
+// copy returns a copy that doesn't share the sensor state.
+func (state *SpeedScannerState) copy() *SpeedScannerState {
+	sensorState := *state.SpeedSensorState
+	c := *state
+	c.SpeedSensorState = &sensorState
+	return &c
 }
 
 func NewSpeedScannerState(deviceID uint32) *SpeedScannerState {
@@ -131,8 +142,9 @@ func NewSpeedScannerState(deviceID uint32) *SpeedScannerState {
 // -------------------------------------------------------------
 type SpeedSensor struct {
 	*AntPlusSensor
+	mu sync.Mutex
 	state *SpeedSensorState
-	listeners []func(*SpeedSensorState)
+	listeners listenerList
 }
 
 func NewSpeedSensor(driver Driver) *SpeedSensor {
@@ -145,18 +157,55 @@ func NewSpeedSensor(driver Driver) *SpeedSensor {
 	return &ss
 }
 
-func (sensor *SpeedSensor) updateState(deviceID uint32, data []byte) {
+func (sensor *SpeedSensor) updateState(id ChannelID, data []byte, ext ExtendedData) {
+	sensor.mu.Lock()
+	sensor.state.DeviceID = id.DeviceNumber
+	sensor.state.ChannelID = id
+	sensor.state.Signal.apply(ext)
 	sensor.state.update(data)
-	for _, cb := range sensor.listeners {
-		cb(sensor.state)
+	state := *sensor.state
+	sensor.mu.Unlock()
+	for _, cb := range sensor.listeners.get() {
+		state := state
+		cb(&state)
 	}
 }
 
-func (sensor *SpeedSensor) ListenForData(cb func(*SpeedSensorState)) {
-	sensor.listeners = append(sensor.listeners, cb)
+// ListenForData registers cb to be called with its own copy of the state
+// every time it is updated, and returns the func removing it. It can be
+// called while data is coming in. cb runs on the driver's reader goroutine,
+// see Subscribe for a consumer that may fall behind.
+func (sensor *SpeedSensor) ListenForData(cb func(*SpeedSensorState)) func() {
+	return sensor.listeners.add(func(state interface{}) {
+		cb(state.(*SpeedSensorState))
+	})
+}
+
+// Subscribe returns a channel receiving a copy of the state every time it is
+// updated, closed once ctx is done. Reading from the stick never waits for
+// the receiver, states it is too slow for are dropped as opts say.
+func (sensor *SpeedSensor) Subscribe(ctx context.Context, opts SubscribeOptions) <-chan *SpeedSensorState {
+	ch := make(chan *SpeedSensorState, opts.buffer())
+	sensor.listeners.subscribe(ctx, ch, opts)
+	return ch
+}
+
+// ChannelConfig returns the channel config Attach uses for deviceID, to be
+// adjusted and passed to AttachConfig.
+func (sensor *SpeedSensor) ChannelConfig(deviceID uint32) ChannelConfig {
+	return sensor.profileConfig(SpeedSensorDeviceType, SpeedSensorPeriod, deviceID)
+}
+
+// Attach opens a channel to the speed sensor with deviceID, zero pairs with
+// the first one found. Like AttachConfig, it can't be called from a
+// callback.
+func (sensor *SpeedSensor) Attach(deviceID uint32) error {
+	return sensor.AttachConfig(sensor.ChannelConfig(deviceID))
 }
 
 func (sensor *SpeedSensor) SetWheelCircumference(wheelCirc float32) {
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
 	sensor.state.WheelCircumference = wheelCirc
 }
 
@@ -165,9 +214,10 @@ func (sensor *SpeedSensor) SetWheelCircumference(wheelCirc float32) {
 // -------------------------------------------------------------
 type SpeedScanner struct {
 	*AntPlusScanner
+	mu sync.Mutex
 	states map[uint32]*SpeedScannerState
 	wheelCircumference float32
-	listeners []func(*SpeedScannerState)
+	listeners listenerList
 }
 
 func NewSpeedScanner(driver Driver) *SpeedScanner {
@@ -184,27 +234,47 @@ func (s *SpeedScanner) deviceType() uint32 {
 }
 
 func (s *SpeedScanner) SetWheelCircumference(deviceID uint32, wheelCirc float32) {
+	s.createStateIfNew(deviceID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.states[deviceID].WheelCircumference = wheelCirc
 }
 
 func (s *SpeedScanner) createStateIfNew(deviceID uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if _, ok := s.states[deviceID]; !ok {
 		s.states[deviceID] = NewSpeedScannerState(deviceID)
 	}
 }
 
-func (s *SpeedScanner) updateRssiAndThreshold(deviceID,  rssi, threshold uint32) {
-	s.states[deviceID].RSSI = rssi
-	s.states[deviceID].Threshold = threshold
-}
-
-func (s *SpeedScanner) updateState(deviceID uint32, data []byte) {
+func (s *SpeedScanner) updateState(id ChannelID, data []byte, ext ExtendedData) {
+	deviceID := id.DeviceNumber
+	s.mu.Lock()
+	s.states[deviceID].ChannelID = id
+	s.states[deviceID].Signal.apply(ext)
 	s.states[deviceID].update(data)
-	for _, cb := range s.listeners {
-		cb(s.states[deviceID])
+	state := s.states[deviceID].copy()
+	s.mu.Unlock()
+	for _, cb := range s.listeners.get() {
+		cb(state.copy())
 	}
 }
 
-func (s *SpeedScanner) ListenForData(cb func(*SpeedScannerState)) {
-	s.listeners = append(s.listeners, cb)
+// ListenForData registers cb to be called with its own copy of a device's
+// state every time it is updated, and returns the func removing it. It can
+// be called while scanning.
+func (s *SpeedScanner) ListenForData(cb func(*SpeedScannerState)) func() {
+	return s.listeners.add(func(state interface{}) {
+		cb(state.(*SpeedScannerState))
+	})
+}
+
+// Subscribe returns a channel receiving a copy of a device's state every
+// time it is updated, closed once ctx is done. States the receiver is too
+// slow for are dropped as opts say.
+func (s *SpeedScanner) Subscribe(ctx context.Context, opts SubscribeOptions) <-chan *SpeedScannerState {
+	ch := make(chan *SpeedScannerState, opts.buffer())
+	s.listeners.subscribe(ctx, ch, opts)
+	return ch
 }
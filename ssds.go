@@ -1,12 +1,20 @@
 package ant
 
 import (
-	"fmt"
+	"context"
+	"sync"
+)
+
+const (
+	StrideSpeedDistanceSensorDeviceType = 124
+	StrideSpeedDistanceSensorPeriod     = 8134
 )
 
 
 type StrideSpeedDistanceSensorState struct {
 	DeviceID          uint32
+	ChannelID         ChannelID
+	Signal
 	OperatingTime	  uint32
 	ManID             byte
 	SerialNumber      uint32
@@ -29,7 +37,6 @@ type StrideSpeedDistanceSensorState struct {
 }
 
 func (s *StrideSpeedDistanceSensorState) update(page *Page, data []byte) {
-	fmt.Println("flskdjf")
 	pageNumber := data[BufferIndexMessageData]
 	if page.pageState == InitPage {
 		page.pageState = StdPage
@@ -115,16 +122,23 @@ func (s *StrideSpeedDistanceSensorState) update(page *Page, data []byte) {
 
 type StrideSpeedDistanceScannerState struct {
 	*StrideSpeedDistanceSensorState
-	RSSI      uint32
-	Threshold uint32
+}
+
+// copy returns a copy that doesn't share the sensor state.
+func (state *StrideSpeedDistanceScannerState) copy() *StrideSpeedDistanceScannerState {
+	sensorState := *state.StrideSpeedDistanceSensorState
+	c := *state
+	c.StrideSpeedDistanceSensorState = &sensorState
+	return &c
 }
 
 type StrideSpeedDistanceSensor struct {
 //TODO do
 	*AntPlusSensor
+	mu sync.Mutex
 	state *StrideSpeedDistanceSensorState
 	page *Page
-	listeners []func(*StrideSpeedDistanceSensorState)
+	listeners listenerList
 }
 
 func NewStrideSpeedDistanceSensor(driver Driver) *StrideSpeedDistanceSensor {
@@ -136,27 +150,62 @@ func NewStrideSpeedDistanceSensor(driver Driver) *StrideSpeedDistanceSensor {
 	return &hrs
 }
 
-func (sensor *StrideSpeedDistanceSensor) updateState(deviceID uint32, data []byte) {
+func (sensor *StrideSpeedDistanceSensor) updateState(id ChannelID, data []byte, ext ExtendedData) {
+	sensor.mu.Lock()
+	sensor.state.DeviceID = id.DeviceNumber
+	sensor.state.ChannelID = id
+	sensor.state.Signal.apply(ext)
 	sensor.state.update(sensor.page, data)
-	for _, cb := range sensor.listeners {
-		cb(sensor.state)
+	state := *sensor.state
+	sensor.mu.Unlock()
+	for _, cb := range sensor.listeners.get() {
+		state := state
+		cb(&state)
 	}
 }
 
-func (sensor *StrideSpeedDistanceSensor) ListenForData(cb func(*StrideSpeedDistanceSensorState)) {
-	sensor.listeners = append(sensor.listeners, cb)
+// ListenForData registers cb to be called with its own copy of the state
+// every time it is updated, and returns the func removing it. It can be
+// called while data is coming in. cb runs on the driver's reader goroutine,
+// see Subscribe for a consumer that may fall behind.
+func (sensor *StrideSpeedDistanceSensor) ListenForData(cb func(*StrideSpeedDistanceSensorState)) func() {
+	return sensor.listeners.add(func(state interface{}) {
+		cb(state.(*StrideSpeedDistanceSensorState))
+	})
+}
+
+// Subscribe returns a channel receiving a copy of the state every time it is
+// updated, closed once ctx is done. Reading from the stick never waits for
+// the receiver, states it is too slow for are dropped as opts say.
+func (sensor *StrideSpeedDistanceSensor) Subscribe(ctx context.Context, opts SubscribeOptions) <-chan *StrideSpeedDistanceSensorState {
+	ch := make(chan *StrideSpeedDistanceSensorState, opts.buffer())
+	sensor.listeners.subscribe(ctx, ch, opts)
+	return ch
+}
+
+// ChannelConfig returns the channel config Attach uses for deviceID, to be
+// adjusted and passed to AttachConfig.
+func (sensor *StrideSpeedDistanceSensor) ChannelConfig(deviceID uint32) ChannelConfig {
+	return sensor.profileConfig(StrideSpeedDistanceSensorDeviceType, StrideSpeedDistanceSensorPeriod, deviceID)
+}
+
+// Attach opens a channel to the stride based speed and distance monitor with
+// deviceID, zero pairs with the first one found. Like AttachConfig, it can't
+// be called from a callback.
+func (sensor *StrideSpeedDistanceSensor) Attach(deviceID uint32) error {
+	return sensor.AttachConfig(sensor.ChannelConfig(deviceID))
 }
 
 
 type StrideSpeedDistanceScanner struct {
 	*AntPlusScanner
+	mu sync.Mutex
 	states map[uint32]*StrideSpeedDistanceScannerState
 	pages map[uint32]*Page
-	listeners []func(*StrideSpeedDistanceScannerState)
+	listeners listenerList
 }
 
 func NewStrideSpeedDistanceScannerState(deviceID uint32) *StrideSpeedDistanceScannerState {
-	fmt.Println("ceating ssds with id: ", deviceID)
 	return &StrideSpeedDistanceScannerState{
 		StrideSpeedDistanceSensorState: &StrideSpeedDistanceSensorState{
 			DeviceID: deviceID,
@@ -170,16 +219,16 @@ func NewStrideSpeedDistanceScanner(driver Driver) *StrideSpeedDistanceScanner {
 		pages: make(map[uint32]*Page),
 	}
 	hrs.AntPlusScanner = NewAntPlusScanner(driver, &hrs)
-	fmt.Println("created pod")
 	return &hrs
 }
 
 func (s *StrideSpeedDistanceScanner) deviceType() uint32 {
-	return 124
+	return StrideSpeedDistanceSensorDeviceType
 }
 
 func (s *StrideSpeedDistanceScanner) createStateIfNew(deviceID uint32) {
-	fmt.Println("create state if new :", deviceID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if _, ok := s.states[deviceID]; !ok {
 		s.states[deviceID] = NewStrideSpeedDistanceScannerState(deviceID)
 	}
@@ -188,18 +237,33 @@ func (s *StrideSpeedDistanceScanner) createStateIfNew(deviceID uint32) {
 	}
 }
 
-func (s *StrideSpeedDistanceScanner) ListenForData(cb func(*StrideSpeedDistanceScannerState)) {
-	s.listeners = append(s.listeners, cb)
+// ListenForData registers cb to be called with its own copy of a device's
+// state every time it is updated, and returns the func removing it. It can
+// be called while scanning.
+func (s *StrideSpeedDistanceScanner) ListenForData(cb func(*StrideSpeedDistanceScannerState)) func() {
+	return s.listeners.add(func(state interface{}) {
+		cb(state.(*StrideSpeedDistanceScannerState))
+	})
 }
 
-func (s *StrideSpeedDistanceScanner) updateRssiAndThreshold(deviceID, rssi, threshold uint32) {
-	s.states[deviceID].RSSI = rssi
-	s.states[deviceID].Threshold = threshold
+// Subscribe returns a channel receiving a copy of a device's state every
+// time it is updated, closed once ctx is done. States the receiver is too
+// slow for are dropped as opts say.
+func (s *StrideSpeedDistanceScanner) Subscribe(ctx context.Context, opts SubscribeOptions) <-chan *StrideSpeedDistanceScannerState {
+	ch := make(chan *StrideSpeedDistanceScannerState, opts.buffer())
+	s.listeners.subscribe(ctx, ch, opts)
+	return ch
 }
 
-func (s *StrideSpeedDistanceScanner) updateState(deviceID uint32, data []byte) {
+func (s *StrideSpeedDistanceScanner) updateState(id ChannelID, data []byte, ext ExtendedData) {
+	deviceID := id.DeviceNumber
+	s.mu.Lock()
+	s.states[deviceID].ChannelID = id
+	s.states[deviceID].Signal.apply(ext)
 	s.states[deviceID].update(s.pages[deviceID], data)
-	for _, cb := range s.listeners {
-		cb(s.states[deviceID])
+	state := s.states[deviceID].copy()
+	s.mu.Unlock()
+	for _, cb := range s.listeners.get() {
+		cb(state.copy())
 	}
 }
@@ -0,0 +1,107 @@
+package ant
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// StickInfo is what a stick reports about itself in its Capabilities,
+// Version and Serial Number responses.
+type StickInfo struct {
+	MaxChannels          int
+	MaxNetworks          int
+	MaxSensRcoreChannels int
+	Version              string
+	SerialNumber         uint32
+
+	// raw option bytes, decoded into the flags below
+	StandardOptions  byte
+	AdvancedOptions  byte
+	AdvancedOptions2 byte
+	AdvancedOptions3 byte
+	AdvancedOptions4 byte
+
+	// standard options
+	NoReceiveChannels      bool
+	NoTransmitChannels     bool
+	NoReceiveMessages      bool
+	NoTransmitMessages     bool
+	NoAcknowledgedMessages bool
+	NoBurstMessages        bool
+
+	// advanced options
+	NetworkEnabled           bool
+	SerialNumberEnabled      bool
+	PerChannelTXPowerEnabled bool
+	LowPrioritySearchEnabled bool
+	ScriptEnabled            bool
+	SearchListEnabled        bool
+
+	// advanced options 2
+	LEDEnabled        bool
+	ExtMessageEnabled bool
+	ScanModeEnabled   bool
+	ProxSearchEnabled bool
+	ExtAssignEnabled  bool
+	FSANTFSEnabled    bool
+}
+
+// CanScan tells if the stick supports continuous scanning mode with
+// extended messages, which scanners rely on.
+func (info StickInfo) CanScan() bool {
+	return info.ExtMessageEnabled && info.ScanModeEnabled
+}
+
+// decodeCapabilities fills info from the payload of a Capabilities
+// response. Older sticks send fewer than the eight bytes.
+func (info *StickInfo) decodeCapabilities(payload []byte) {
+	option := func(idx int) byte {
+		if idx < len(payload) {
+			return payload[idx]
+		}
+		return 0
+	}
+	info.MaxChannels = int(option(0))
+	info.MaxNetworks = int(option(1))
+	info.StandardOptions = option(2)
+	info.AdvancedOptions = option(3)
+	info.AdvancedOptions2 = option(4)
+	info.MaxSensRcoreChannels = int(option(5))
+	info.AdvancedOptions3 = option(6)
+	info.AdvancedOptions4 = option(7)
+
+	std := info.StandardOptions
+	info.NoReceiveChannels = std&CapabilitiesNoReceiveChannels != 0
+	info.NoTransmitChannels = std&CapabilitiesNoTransmitChannels != 0
+	info.NoReceiveMessages = std&CapabilitiesNoReceiveMessages != 0
+	info.NoTransmitMessages = std&CapabilitiesNoTransmitMessages != 0
+	info.NoAcknowledgedMessages = std&CapabilitiesNoAcknowledgedMessages != 0
+	info.NoBurstMessages = std&CapabilitiesNoBurstMessages != 0
+
+	adv := info.AdvancedOptions
+	info.NetworkEnabled = adv&CapabilitiesNetworkEnabled != 0
+	info.SerialNumberEnabled = adv&CapabilitiesSerialNumberEnabled != 0
+	info.PerChannelTXPowerEnabled = adv&CapabilitiesPerChannelTXPowerEnabled != 0
+	info.LowPrioritySearchEnabled = adv&CapabilitiesLowPrioritySearchEnabled != 0
+	info.ScriptEnabled = adv&CapabilitiesScriptEnabled != 0
+	info.SearchListEnabled = adv&CapabilitiesSearchListEnabled != 0
+
+	adv2 := info.AdvancedOptions2
+	info.LEDEnabled = adv2&CapabilitiesLEDEnabled != 0
+	info.ExtMessageEnabled = adv2&CapabilitiesExtMessageEnabled != 0
+	info.ScanModeEnabled = adv2&CapabilitiesScanModeEnabled != 0
+	info.ProxSearchEnabled = adv2&CapabilitiesProxSearchEnabled != 0
+	info.ExtAssignEnabled = adv2&CapabilitiesExtAssignEnabled != 0
+	info.FSANTFSEnabled = adv2&CapabilitiesFSANTFSEnabled != 0
+}
+
+// decodeVersion reads the zero padded version string of a Version response.
+func (info *StickInfo) decodeVersion(payload []byte) {
+	info.Version = string(bytes.TrimRight(payload, "\x00"))
+}
+
+func (info *StickInfo) decodeSerialNumber(payload []byte) {
+	if len(payload) >= 4 {
+		info.SerialNumber = binary.LittleEndian.Uint32(payload)
+	}
+}
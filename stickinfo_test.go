@@ -0,0 +1,58 @@
+package ant
+
+import "testing"
+
+func TestDecodeCapabilities(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		want    StickInfo
+	}{
+		{
+			name:    "short payload",
+			payload: []byte{8, 3},
+			want:    StickInfo{MaxChannels: 8, MaxNetworks: 3},
+		},
+		{
+			name: "scanning stick",
+			payload: []byte{8, 8, 0,
+				CapabilitiesNetworkEnabled | CapabilitiesSerialNumberEnabled,
+				CapabilitiesExtMessageEnabled | CapabilitiesScanModeEnabled, 0, 0, 0},
+			want: StickInfo{
+				MaxChannels:         8,
+				MaxNetworks:         8,
+				AdvancedOptions:     0x0A,
+				AdvancedOptions2:    0x06,
+				NetworkEnabled:      true,
+				SerialNumberEnabled: true,
+				ExtMessageEnabled:   true,
+				ScanModeEnabled:     true,
+			},
+		},
+		{
+			name:    "receive only",
+			payload: []byte{4, 1, CapabilitiesNoTransmitChannels | CapabilitiesNoBurstMessages, 0, 0, 2},
+			want: StickInfo{
+				MaxChannels:          4,
+				MaxNetworks:          1,
+				MaxSensRcoreChannels: 2,
+				StandardOptions:      0x22,
+				NoTransmitChannels:   true,
+				NoBurstMessages:      true,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var info StickInfo
+			info.decodeCapabilities(tt.payload)
+			if info != tt.want {
+				t.Errorf("got %+v\nwant %+v", info, tt.want)
+			}
+		})
+	}
+	scanner := StickInfo{ExtMessageEnabled: true, ScanModeEnabled: true}
+	if !scanner.CanScan() || (StickInfo{ScanModeEnabled: true}).CanScan() {
+		t.Error("CanScan needs both extended messages and scan mode")
+	}
+}
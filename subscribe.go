@@ -0,0 +1,106 @@
+package ant
+
+import (
+	"context"
+	"reflect"
+	"sync"
+)
+
+// DropPolicy is what a subscription does with a new state while its buffer
+// is full.
+type DropPolicy int
+
+const (
+	// DropOldest discards the oldest buffered state to make room
+	DropOldest DropPolicy = iota
+	// DropNewest discards the new state
+	DropNewest
+)
+
+// DefaultSubscribeBuffer is how many states a subscription holds when
+// SubscribeOptions.Buffer isn't set.
+const DefaultSubscribeBuffer = 16
+
+// SubscribeOptions tune the channel returned by the Subscribe methods. The
+// zero value buffers DefaultSubscribeBuffer states and drops the oldest.
+type SubscribeOptions struct {
+	Buffer int
+	Drop   DropPolicy
+}
+
+func (opts SubscribeOptions) buffer() int {
+	if opts.Buffer > 0 {
+		return opts.Buffer
+	}
+	return DefaultSubscribeBuffer
+}
+
+type listener struct {
+	fn func(interface{})
+}
+
+// listenerList holds the callbacks of a sensor or scanner, each can be
+// removed again. It has its own lock, the callbacks are called without it.
+type listenerList struct {
+	mu        sync.Mutex
+	listeners []*listener
+}
+
+// add registers fn and returns the func removing it, which can be called
+// more than once.
+func (l *listenerList) add(fn func(interface{})) func() {
+	entry := &listener{fn: fn}
+	l.mu.Lock()
+	l.listeners = append(l.listeners, entry)
+	l.mu.Unlock()
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for idx, e := range l.listeners {
+			if e == entry {
+				l.listeners = append(l.listeners[:idx:idx], l.listeners[idx+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// get returns the callbacks registered right now.
+func (l *listenerList) get() []func(interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fns := make([]func(interface{}), len(l.listeners))
+	for idx, e := range l.listeners {
+		fns[idx] = e.fn
+	}
+	return fns
+}
+
+// subscribe feeds ch, a buffered channel of the states the list is called
+// with, until ctx is done and ch is closed. It never blocks the caller,
+// states that don't fit are dropped as opts say.
+func (l *listenerList) subscribe(ctx context.Context, ch interface{}, opts SubscribeOptions) {
+	out := reflect.ValueOf(ch)
+	var mu sync.Mutex
+	closed := false
+	remove := l.add(func(state interface{}) {
+		v := reflect.ValueOf(state)
+		mu.Lock()
+		defer mu.Unlock()
+		if closed || out.TrySend(v) {
+			return
+		}
+		if opts.Drop == DropOldest {
+			out.TryRecv()
+			out.TrySend(v)
+		}
+	})
+	go func() {
+		<-ctx.Done()
+		remove()
+		mu.Lock()
+		closed = true
+		out.Close()
+		mu.Unlock()
+	}()
+}
@@ -0,0 +1,332 @@
+package ant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/gousb"
+)
+
+const (
+	GarminVendorID        gousb.ID = 0x0FCF
+	GarminStick2ProductID gousb.ID = 0x1008
+	GarminStick3ProductID gousb.ID = 0x1009
+)
+
+var ErrNoStick = errors.New("no free usb stick found")
+
+// usbTransport talks to an ANT stick over its bulk endpoints.
+type usbTransport struct {
+	device     *gousb.Device
+	intf       *gousb.Interface
+	intfDone   func()
+	inEp       *gousb.InEndpoint
+	inEpReader *gousb.ReadStream
+	outEp      *gousb.OutEndpoint
+	serial     string
+}
+
+// stickLocation is where a device sits on the bus. Unlike descriptors it
+// stays the same from one enumeration to the next.
+type stickLocation struct {
+	bus     int
+	address int
+}
+
+func locate(desc *gousb.DeviceDesc) stickLocation {
+	return stickLocation{bus: desc.Bus, address: desc.Address}
+}
+
+// openUSBTransport claims the first matching stick nobody else has claimed,
+// trying the one with the given usb serial number first. Sticks at a
+// location in skip are left alone.
+func openUSBTransport(ctx *gousb.Context, vendorID, productID gousb.ID, serial string,
+	skip []stickLocation) (*usbTransport, error) {
+	devs, err := ctx.OpenDevices(func(desc *gousb.DeviceDesc) bool {
+		if desc.Vendor != vendorID || desc.Product != productID {
+			return false
+		}
+		for _, loc := range skip {
+			if loc == locate(desc) {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil && len(devs) == 0 {
+		return nil, err
+	}
+	if serial != "" {
+		for idx, dev := range devs {
+			if s, err := dev.SerialNumber(); err == nil && s == serial {
+				devs[0], devs[idx] = devs[idx], devs[0]
+				break
+			}
+		}
+	}
+	var t *usbTransport
+	for _, dev := range devs {
+		if t != nil {
+			// close any other device that may have been open
+			dev.Close()
+			continue
+		}
+		// a stick claimed by another driver, in this process or not,
+		// fails here and we move on to the next
+		t, _ = claimUSBTransport(dev)
+	}
+	if t == nil {
+		return nil, ErrNoStick
+	}
+	return t, nil
+}
+
+// claimUSBTransport claims the interface of dev, dev is closed if that fails.
+func claimUSBTransport(dev *gousb.Device) (*usbTransport, error) {
+	t := &usbTransport{device: dev}
+	t.serial, _ = dev.SerialNumber()
+	dev.SetAutoDetach(true)
+	var err error
+	t.intf, t.intfDone, err = dev.DefaultInterface()
+	if err != nil {
+		dev.Close()
+		return nil, err
+	}
+	t.inEp, err = t.intf.InEndpoint(1)
+	if err != nil {
+		t.release()
+		return nil, fmt.Errorf("in endpoint: %w", err)
+	}
+
+	maxPacketSize := t.inEp.Desc.MaxPacketSize
+	t.inEpReader, err = t.inEp.NewStream(maxPacketSize, 3)
+	if err != nil {
+		t.release()
+		return nil, fmt.Errorf("in endpoint stream: %w", err)
+	}
+
+	t.outEp, err = t.intf.OutEndpoint(1)
+	if err != nil {
+		t.inEpReader.Close()
+		t.release()
+		return nil, fmt.Errorf("out endpoint: %w", err)
+	}
+	return t, nil
+}
+
+func (t *usbTransport) release() error {
+	t.intfDone()
+	return t.device.Close()
+}
+
+func (t *usbTransport) Read(data []byte) (int, error) {
+	return t.inEpReader.Read(data)
+}
+
+func (t *usbTransport) Write(data []byte) (int, error) {
+	return t.outEp.Write(data)
+}
+
+func (t *usbTransport) Close() error {
+	t.inEpReader.Close()
+	return t.release()
+}
+
+type USBDriver struct {
+	BaseDriver
+	vendorID  gousb.ID
+	productID gousb.ID
+	usb       *gousb.Context
+	usbSerial string
+	// when not zero, only the stick with this ANT serial number will do
+	antSerial uint32
+
+	// ReconnectInterval is how often to look for the stick again once it
+	// has been unplugged, zero leaves the driver stopped instead.
+	ReconnectInterval time.Duration
+}
+
+var errWrongStick = errors.New("stick has another serial number")
+
+func NewUSBDriver(vendorID, productID gousb.ID) *USBDriver {
+	return &USBDriver{
+		vendorID:          vendorID,
+		productID:         productID,
+		ReconnectInterval: DefaultReconnectInterval,
+	}
+}
+
+// Open is OpenContext bounded by DefaultStartupTimeout.
+func (drv *USBDriver) Open(usb *gousb.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultStartupTimeout)
+	defer cancel()
+	return drv.OpenContext(ctx, usb)
+}
+
+// OpenContext claims the first free stick and blocks until it is ready to
+// attach sensors.
+func (drv *USBDriver) OpenContext(ctx context.Context, usb *gousb.Context) error {
+	_, err := drv.open(ctx, usb, 0, nil)
+	return err
+}
+
+// OpenSerial is OpenSerialContext bounded by DefaultStartupTimeout.
+func (drv *USBDriver) OpenSerial(usb *gousb.Context, serial uint32) error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultStartupTimeout)
+	defer cancel()
+	return drv.OpenSerialContext(ctx, usb, serial)
+}
+
+// OpenSerialContext is like OpenContext but only accepts the stick with the
+// given ANT serial number, see ListSticks. The driver sticks to that serial
+// number when reconnecting as well.
+func (drv *USBDriver) OpenSerialContext(ctx context.Context, usb *gousb.Context, serial uint32) error {
+	_, err := drv.open(ctx, usb, serial, nil)
+	return err
+}
+
+// open claims a stick not in skip and starts it. It returns where the stick
+// it claimed last is, nil if it couldn't claim any.
+func (drv *USBDriver) open(ctx context.Context, usb *gousb.Context, serial uint32,
+	skip []stickLocation) (*stickLocation, error) {
+	drv.usb = usb
+	drv.antSerial = serial
+	drv.verify = drv.checkSerial
+	if drv.ReconnectInterval > 0 {
+		drv.enableReconnect(drv.ReconnectInterval, drv.reopenStick)
+	}
+	skip = append([]stickLocation{}, skip...)
+	for {
+		t, err := openUSBTransport(usb, drv.vendorID, drv.productID, drv.usbSerial, skip)
+		if err == ErrNoStick && serial != 0 {
+			return nil, fmt.Errorf("no free usb stick with serial number %d", serial)
+		}
+		if err != nil {
+			return nil, err
+		}
+		loc := locate(t.device.Desc)
+		err = drv.StartContext(ctx, t)
+		if err != errWrongStick {
+			if err == nil {
+				drv.usbSerial = t.serial
+			}
+			return &loc, err
+		}
+		skip = append(skip, loc)
+	}
+}
+
+func (drv *USBDriver) checkSerial() error {
+	if drv.antSerial != 0 && drv.StickInfo().SerialNumber != drv.antSerial {
+		return errWrongStick
+	}
+	return nil
+}
+
+// reopenStick looks for the stick we lost, or one just like it.
+func (drv *USBDriver) reopenStick() (Transport, error) {
+	t, err := openUSBTransport(drv.usb, drv.vendorID, drv.productID, drv.usbSerial, nil)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// StickListing describes one ANT stick found by ListSticks. The ANT details
+// are only filled in for sticks that were free to be opened.
+type StickListing struct {
+	Vendor  gousb.ID
+	Product gousb.ID
+	// Path is where the stick is plugged in, as bus-port.port...
+	Path  string
+	InUse bool
+	StickInfo
+}
+
+// KnownSticks are the vendor and product ids ListSticks looks for.
+var KnownSticks = [][2]gousb.ID{
+	{GarminVendorID, GarminStick2ProductID},
+	{GarminVendorID, GarminStick3ProductID},
+}
+
+func isKnownStick(desc *gousb.DeviceDesc) bool {
+	for _, ids := range KnownSticks {
+		if desc.Vendor == ids[0] && desc.Product == ids[1] {
+			return true
+		}
+	}
+	return false
+}
+
+func busPath(desc *gousb.DeviceDesc) string {
+	ports := make([]string, len(desc.Path))
+	for idx, port := range desc.Path {
+		ports[idx] = strconv.Itoa(port)
+	}
+	return fmt.Sprintf("%d-%s", desc.Bus, strings.Join(ports, "."))
+}
+
+// ListSticks lists every known ANT stick plugged in. Free sticks are briefly
+// opened to ask for their serial number, version and capabilities, a stick
+// that doesn't answer is listed without them.
+func ListSticks(usb *gousb.Context) ([]StickListing, error) {
+	devs, err := usb.OpenDevices(isKnownStick)
+	if err != nil && len(devs) == 0 {
+		return nil, err
+	}
+	listings := []StickListing{}
+	for _, dev := range devs {
+		listing := StickListing{
+			Vendor:  dev.Desc.Vendor,
+			Product: dev.Desc.Product,
+			Path:    busPath(dev.Desc),
+		}
+		t, err := claimUSBTransport(dev)
+		if err != nil {
+			listing.InUse = true
+			listings = append(listings, listing)
+			continue
+		}
+		drv := NewBaseDriver()
+		if err := drv.Start(t); err != nil {
+			listings = append(listings, listing)
+			continue
+		}
+		listing.StickInfo = drv.StickInfo()
+		drv.Close()
+		listings = append(listings, listing)
+	}
+	return listings, nil
+}
+
+type GarminStick2 struct {
+	USBDriver
+}
+
+func NewGarminStick2() *GarminStick2 {
+	return &GarminStick2{
+		USBDriver: USBDriver{
+			vendorID:          GarminVendorID,
+			productID:         GarminStick2ProductID,
+			ReconnectInterval: DefaultReconnectInterval,
+		},
+	}
+}
+
+type GarminStick3 struct {
+	USBDriver
+}
+
+func NewGarminStick3() *GarminStick3 {
+	return &GarminStick3{
+		USBDriver: USBDriver{
+			vendorID:          GarminVendorID,
+			productID:         GarminStick3ProductID,
+			ReconnectInterval: DefaultReconnectInterval,
+		},
+	}
+}
@@ -0,0 +1,33 @@
+package ant
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWrongStickIsNotADisconnect(t *testing.T) {
+	sim := NewSimulatedStick()
+	sim.SerialNumber = 1234
+	drv := NewUSBDriver(0, 0)
+	drv.antSerial = 5678
+	drv.verify = drv.checkSerial
+	var reopened, disconnects int32
+	drv.enableReconnect(time.Millisecond, func() (Transport, error) {
+		atomic.AddInt32(&reopened, 1)
+		return sim.reopen(), nil
+	})
+	drv.OnDisconnect(func(error) { atomic.AddInt32(&disconnects, 1) })
+
+	if err := drv.StartContext(context.Background(), sim.reopen()); err != errWrongStick {
+		t.Fatalf("got %v, want errWrongStick", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt32(&disconnects); n != 0 {
+		t.Errorf("OnDisconnect called %d times", n)
+	}
+	if n := atomic.LoadInt32(&reopened); n != 0 {
+		t.Errorf("stick reopened %d times", n)
+	}
+}